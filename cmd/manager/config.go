@@ -0,0 +1,443 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stolostron/hub-of-hubs-status-sync/pkg/dbsyncers"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	configAPIVersion = "status-sync.hub-of-hubs.open-cluster-management.io/v1alpha1"
+	configKind       = "StatusSyncConfiguration"
+)
+
+var errInvalidConfig = errors.New("invalid configuration")
+
+// managerConfig is the versioned configuration file of the manager. every field can be overridden by its environment
+// variable, see applyEnvironmentOverrides.
+type managerConfig struct {
+	APIVersion        string                   `json:"apiVersion"`
+	Kind              string                   `json:"kind"`
+	Database          databaseConfig           `json:"database"`
+	LeaderElection    leaderElectionConfig     `json:"leaderElection"`
+	Server            serverConfig             `json:"server"`
+	Workers           workersConfig            `json:"workers"`
+	Sync              syncConfig               `json:"sync"`
+	LeafHubs          leafHubsConfig           `json:"leafHubs"`
+	GarbageCollection garbageCollectionConfig  `json:"garbageCollection"`
+	Syncers           map[string]*syncerConfig `json:"syncers,omitempty"`
+	// UnstructuredSyncersFile is the path of the optional unstructured DB syncers configuration file.
+	UnstructuredSyncersFile string `json:"unstructuredSyncersFile,omitempty"`
+}
+
+type databaseConfig struct {
+	// URL is better set by the DATABASE_URL environment variable, from a secret.
+	URL string `json:"url,omitempty"`
+}
+
+type leaderElectionConfig struct {
+	Enabled   bool   `json:"enabled"`
+	ID        string `json:"id"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type serverConfig struct {
+	MetricsBindAddress     string `json:"metricsBindAddress"`
+	HealthProbeBindAddress string `json:"healthProbeBindAddress"`
+}
+
+type workersConfig struct {
+	PoolSize          int `json:"poolSize"`
+	SyncerConcurrency int `json:"syncerConcurrency"`
+}
+
+type syncConfig struct {
+	Interval              metav1.Duration `json:"interval"`
+	ResyncInterval        metav1.Duration `json:"resyncInterval"`
+	EventDriven           bool            `json:"eventDriven"`
+	Incremental           bool            `json:"incremental"`
+	HealthTickMultiple    int             `json:"healthTickMultiple"`
+	ShutdownGracePeriod   metav1.Duration `json:"shutdownGracePeriod"`
+	ServerSideApply       bool            `json:"serverSideApply"`
+	ForceOwnership        bool            `json:"forceOwnership"`
+	WriteRetries          int             `json:"writeRetries"`
+	WriteRetryInterval    metav1.Duration `json:"writeRetryInterval"`
+	WriteRetryMaxInterval metav1.Duration `json:"writeRetryMaxInterval"`
+	DryRun                bool            `json:"dryRun"`
+	DryRunFile            string          `json:"dryRunFile,omitempty"`
+}
+
+type leafHubsConfig struct {
+	StaleThreshold      metav1.Duration                     `json:"staleThreshold"`
+	StalePolicy         dbsyncers.StaleLeafHubPolicy        `json:"stalePolicy"`
+	DuplicateClusters   dbsyncers.DuplicateClustersStrategy `json:"duplicateClusters"`
+	QualifyClusterNames bool                                `json:"qualifyClusterNames"`
+}
+
+type garbageCollectionConfig struct {
+	Interval metav1.Duration `json:"interval"`
+	DryRun   bool            `json:"dryRun"`
+}
+
+type syncerConfig struct {
+	Enabled     bool            `json:"enabled"`
+	Interval    metav1.Duration `json:"interval"`
+	Concurrency int             `json:"concurrency"`
+}
+
+func newDefaultSyncerConfig() *syncerConfig {
+	return &syncerConfig{Enabled: true}
+}
+
+// UnmarshalJSON keeps the syncers enabled unless disabled explicitly.
+func (config *syncerConfig) UnmarshalJSON(data []byte) error {
+	type plainSyncerConfig syncerConfig // without the UnmarshalJSON method
+
+	plainConfig := plainSyncerConfig(*newDefaultSyncerConfig())
+
+	if err := yaml.UnmarshalStrict(data, &plainConfig); err != nil {
+		return fmt.Errorf("failed to parse syncer configuration: %w", err)
+	}
+
+	*config = syncerConfig(plainConfig)
+
+	return nil
+}
+
+func newDefaultManagerConfig() *managerConfig {
+	return &managerConfig{
+		APIVersion: configAPIVersion,
+		Kind:       configKind,
+		LeaderElection: leaderElectionConfig{
+			Enabled: true,
+			ID:      defaultLeaderElectionID,
+		},
+		Server: serverConfig{
+			MetricsBindAddress:     defaultMetricsBindAddress,
+			HealthProbeBindAddress: defaultHealthProbeBindAddress,
+		},
+		Workers: workersConfig{
+			PoolSize:          defaultWorkerPoolSize,
+			SyncerConcurrency: defaultSyncerConcurrency,
+		},
+		Sync: syncConfig{
+			ResyncInterval:        metav1.Duration{Duration: defaultResyncInterval},
+			HealthTickMultiple:    dbsyncers.DefaultHealthTickMultiple,
+			ShutdownGracePeriod:   metav1.Duration{Duration: defaultShutdownGracePeriod},
+			WriteRetries:          defaultWriteRetries,
+			WriteRetryInterval:    metav1.Duration{Duration: defaultWriteRetryInterval},
+			WriteRetryMaxInterval: metav1.Duration{Duration: defaultWriteRetryMaxInterval},
+		},
+		LeafHubs: leafHubsConfig{
+			StalePolicy:       dbsyncers.StaleLeafHubPolicyDrop,
+			DuplicateClusters: dbsyncers.DuplicateClustersKeepAll,
+		},
+		Syncers: map[string]*syncerConfig{},
+	}
+}
+
+// readManagerConfig returns the default configuration, overridden by the configuration file if set and by the
+// environment variables.
+func readManagerConfig(configFile string) (*managerConfig, error) {
+	config := newDefaultManagerConfig()
+
+	if configFile != "" {
+		data, err := ioutil.ReadFile(configFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read configuration file %s: %w", configFile, err)
+		}
+
+		if err := yaml.UnmarshalStrict(data, config); err != nil {
+			return nil, fmt.Errorf("%w: failed to parse configuration file %s: %v", errInvalidConfig, configFile, err)
+		}
+
+		if config.Syncers == nil {
+			config.Syncers = map[string]*syncerConfig{}
+		}
+
+		for syncerName, syncer := range config.Syncers {
+			if syncer == nil { // listed without settings
+				config.Syncers[syncerName] = newDefaultSyncerConfig()
+			}
+		}
+	}
+
+	if err := config.applyEnvironmentOverrides(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// applyEnvironmentOverrides overrides the configuration by the environment variables that are set.
+func (config *managerConfig) applyEnvironmentOverrides() error {
+	overrides := &environmentOverrides{}
+
+	overrides.string(environmentVariableDatabaseURL, &config.Database.URL)
+	overrides.string(environmentVariableControllerNamespace, &config.LeaderElection.Namespace)
+	overrides.duration(environmentVariableSyncInterval, &config.Sync.Interval)
+	overrides.int(environmentVariableWorkerPoolSize, &config.Workers.PoolSize)
+	overrides.int(environmentVariableSyncerConcurrency, &config.Workers.SyncerConcurrency)
+	overrides.bool(environmentVariableEventDriven, &config.Sync.EventDriven)
+	overrides.bool(environmentVariableIncremental, &config.Sync.Incremental)
+	overrides.duration(environmentVariableResyncInterval, &config.Sync.ResyncInterval)
+	overrides.int(environmentVariableHealthTickMultiple, &config.Sync.HealthTickMultiple)
+	overrides.duration(environmentVariableShutdownGracePeriod, &config.Sync.ShutdownGracePeriod)
+	overrides.string(environmentVariableUnstructuredSyncers, &config.UnstructuredSyncersFile)
+	overrides.duration(environmentVariableGCInterval, &config.GarbageCollection.Interval)
+	overrides.bool(environmentVariableGCDryRun, &config.GarbageCollection.DryRun)
+	overrides.duration(environmentVariableStaleThreshold, &config.LeafHubs.StaleThreshold)
+	overrides.string(environmentVariableStalePolicy, (*string)(&config.LeafHubs.StalePolicy))
+	overrides.string(environmentVariableDuplicateClusters, (*string)(&config.LeafHubs.DuplicateClusters))
+	overrides.bool(environmentVariableQualifyClusterNames, &config.LeafHubs.QualifyClusterNames)
+	overrides.bool(environmentVariableServerSideApply, &config.Sync.ServerSideApply)
+	overrides.bool(environmentVariableForceOwnership, &config.Sync.ForceOwnership)
+	overrides.int(environmentVariableWriteRetries, &config.Sync.WriteRetries)
+	overrides.duration(environmentVariableRetryInterval, &config.Sync.WriteRetryInterval)
+	overrides.duration(environmentVariableRetryMaxInterval, &config.Sync.WriteRetryMaxInterval)
+	overrides.bool(environmentVariableDryRun, &config.Sync.DryRun)
+	overrides.string(environmentVariableDryRunFile, &config.Sync.DryRunFile)
+
+	return overrides.err()
+}
+
+// applySyncerEnvironmentOverrides overrides the configuration of the DB syncers by their environment variables.
+func (config *managerConfig) applySyncerEnvironmentOverrides(syncerNames []string) error {
+	overrides := &environmentOverrides{}
+
+	for _, syncerName := range syncerNames {
+		syncer, found := config.Syncers[syncerName]
+		if !found {
+			syncer = newDefaultSyncerConfig()
+			config.Syncers[syncerName] = syncer
+		}
+
+		overrides.bool(syncerEnvironmentVariable(syncerName, syncerEnabledSuffix), &syncer.Enabled)
+		overrides.duration(syncerEnvironmentVariable(syncerName, syncerIntervalSuffix), &syncer.Interval)
+		overrides.int(syncerEnvironmentVariable(syncerName, syncerConcurrencySuffix), &syncer.Concurrency)
+	}
+
+	return overrides.err()
+}
+
+// validate returns an error that lists all the invalid fields, the DB syncers must be registered by syncerNames.
+func (config *managerConfig) validate(syncerNames []string) error {
+	var problems []string
+
+	addProblem := func(field string, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if config.APIVersion != configAPIVersion {
+		addProblem("apiVersion", "must be %s, got %q", configAPIVersion, config.APIVersion)
+	}
+
+	if config.Kind != configKind {
+		addProblem("kind", "must be %s, got %q", configKind, config.Kind)
+	}
+
+	if config.Database.URL == "" {
+		addProblem("database.url", "must be set, e.g. by %s", environmentVariableDatabaseURL)
+	}
+
+	if config.LeaderElection.Enabled && (config.LeaderElection.ID == "" || config.LeaderElection.Namespace == "") {
+		addProblem("leaderElection", "id and namespace must be set, the namespace e.g. by %s",
+			environmentVariableControllerNamespace)
+	}
+
+	positiveDurations := map[string]time.Duration{
+		"sync.interval":              config.Sync.Interval.Duration,
+		"sync.resyncInterval":        config.Sync.ResyncInterval.Duration,
+		"sync.writeRetryInterval":    config.Sync.WriteRetryInterval.Duration,
+		"sync.writeRetryMaxInterval": config.Sync.WriteRetryMaxInterval.Duration,
+	}
+
+	for field, value := range positiveDurations {
+		if value <= 0 {
+			addProblem(field, "must be a positive duration, got %v", value)
+		}
+	}
+
+	nonNegativeDurations := map[string]time.Duration{
+		"sync.shutdownGracePeriod":   config.Sync.ShutdownGracePeriod.Duration,
+		"leafHubs.staleThreshold":    config.LeafHubs.StaleThreshold.Duration,
+		"garbageCollection.interval": config.GarbageCollection.Interval.Duration,
+	}
+
+	for field, value := range nonNegativeDurations {
+		if value < 0 {
+			addProblem(field, "must not be a negative duration, got %v", value)
+		}
+	}
+
+	positiveCounts := map[string]int{
+		"workers.poolSize":          config.Workers.PoolSize,
+		"workers.syncerConcurrency": config.Workers.SyncerConcurrency,
+		"sync.healthTickMultiple":   config.Sync.HealthTickMultiple,
+	}
+
+	for field, value := range positiveCounts {
+		if value <= 0 {
+			addProblem(field, "must be positive, got %d", value)
+		}
+	}
+
+	if config.Sync.WriteRetries < 0 {
+		addProblem("sync.writeRetries", "must not be negative, got %d", config.Sync.WriteRetries)
+	}
+
+	if config.Server.MetricsBindAddress == "" || config.Server.HealthProbeBindAddress == "" {
+		addProblem("server", "metricsBindAddress and healthProbeBindAddress must be set, \"0\" disables them")
+	}
+
+	if config.Sync.EventDriven && config.Sync.Incremental {
+		addProblem("sync", "eventDriven and incremental are mutually exclusive")
+	}
+
+	switch config.LeafHubs.StalePolicy {
+	case dbsyncers.StaleLeafHubPolicyDrop, dbsyncers.StaleLeafHubPolicyMark:
+	default:
+		addProblem("leafHubs.stalePolicy", "must be %s or %s, got %q", dbsyncers.StaleLeafHubPolicyDrop,
+			dbsyncers.StaleLeafHubPolicyMark, config.LeafHubs.StalePolicy)
+	}
+
+	switch config.LeafHubs.DuplicateClusters {
+	case dbsyncers.DuplicateClustersKeepAll, dbsyncers.DuplicateClustersPreferMostRecent,
+		dbsyncers.DuplicateClustersQualify:
+	default:
+		addProblem("leafHubs.duplicateClusters", "must be %s, %s or %s, got %q", dbsyncers.DuplicateClustersKeepAll,
+			dbsyncers.DuplicateClustersPreferMostRecent, dbsyncers.DuplicateClustersQualify,
+			config.LeafHubs.DuplicateClusters)
+	}
+
+	knownSyncers := map[string]struct{}{}
+	for _, syncerName := range syncerNames {
+		knownSyncers[syncerName] = struct{}{}
+	}
+
+	for syncerName, syncer := range config.Syncers {
+		if _, found := knownSyncers[syncerName]; !found {
+			addProblem("syncers."+syncerName, "unknown DB syncer, known DB syncers are %s",
+				strings.Join(syncerNames, ", "))
+		}
+
+		if syncer.Interval.Duration < 0 || syncer.Concurrency < 0 {
+			addProblem("syncers."+syncerName, "interval and concurrency must not be negative")
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	sort.Strings(problems)
+
+	return fmt.Errorf("%w:\n  %s", errInvalidConfig, strings.Join(problems, "\n  "))
+}
+
+// dbSyncersConfig returns the configuration of the DB syncers.
+func (config *managerConfig) dbSyncersConfig() *dbsyncers.Config {
+	syncersConfig := make(map[string]dbsyncers.SyncerConfig, len(config.Syncers))
+
+	for syncerName, syncer := range config.Syncers {
+		syncersConfig[syncerName] = dbsyncers.SyncerConfig{
+			Disabled:     !syncer.Enabled,
+			SyncInterval: syncer.Interval.Duration,
+			Concurrency:  syncer.Concurrency,
+		}
+	}
+
+	return &dbsyncers.Config{
+		SyncInterval:              config.Sync.Interval.Duration,
+		ResyncInterval:            config.Sync.ResyncInterval.Duration,
+		WorkerPoolSize:            config.Workers.PoolSize,
+		SyncerConcurrency:         config.Workers.SyncerConcurrency,
+		EventDriven:               config.Sync.EventDriven,
+		Incremental:               config.Sync.Incremental,
+		HealthTickMultiple:        config.Sync.HealthTickMultiple,
+		ShutdownGracePeriod:       config.Sync.ShutdownGracePeriod.Duration,
+		StaleLeafHubThreshold:     config.LeafHubs.StaleThreshold.Duration,
+		StaleLeafHubPolicy:        config.LeafHubs.StalePolicy,
+		DuplicateClustersStrategy: config.LeafHubs.DuplicateClusters,
+		QualifyClusterNames:       config.LeafHubs.QualifyClusterNames,
+		ServerSideApply:           config.Sync.ServerSideApply,
+		ForceOwnership:            config.Sync.ForceOwnership,
+		WriteRetries:              config.Sync.WriteRetries,
+		WriteRetryInitialInterval: config.Sync.WriteRetryInterval.Duration,
+		WriteRetryMaxInterval:     config.Sync.WriteRetryMaxInterval.Duration,
+		DryRun:                    config.Sync.DryRun,
+		DryRunFile:                config.Sync.DryRunFile,
+		GarbageCollectionInterval: config.GarbageCollection.Interval.Duration,
+		GarbageCollectionDryRun:   config.GarbageCollection.DryRun,
+		Syncers:                   syncersConfig,
+	}
+}
+
+// environmentOverrides overrides configuration fields by the environment variables that are set, and collects the
+// variables that could not be parsed.
+type environmentOverrides struct {
+	problems []string
+}
+
+func (overrides *environmentOverrides) string(name string, target *string) {
+	if value, found := os.LookupEnv(name); found {
+		*target = value
+	}
+}
+
+func (overrides *environmentOverrides) bool(name string, target *bool) {
+	if valueString, found := os.LookupEnv(name); found {
+		value, err := strconv.ParseBool(valueString)
+		if err != nil {
+			overrides.problems = append(overrides.problems, fmt.Sprintf("%s: not a valid boolean", name))
+			return
+		}
+
+		*target = value
+	}
+}
+
+func (overrides *environmentOverrides) int(name string, target *int) {
+	if valueString, found := os.LookupEnv(name); found {
+		value, err := strconv.Atoi(valueString)
+		if err != nil {
+			overrides.problems = append(overrides.problems, fmt.Sprintf("%s: not a valid integer", name))
+			return
+		}
+
+		*target = value
+	}
+}
+
+func (overrides *environmentOverrides) duration(name string, target *metav1.Duration) {
+	if valueString, found := os.LookupEnv(name); found {
+		value, err := time.ParseDuration(valueString)
+		if err != nil {
+			overrides.problems = append(overrides.problems, fmt.Sprintf("%s: not a valid duration", name))
+			return
+		}
+
+		target.Duration = value
+	}
+}
+
+func (overrides *environmentOverrides) err() error {
+	if len(overrides.problems) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%w: invalid environment variables:\n  %s", errInvalidConfig,
+		strings.Join(overrides.problems, "\n  "))
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -23,11 +24,47 @@ import (
 )
 
 const (
-	metricsHost                                  = "0.0.0.0"
-	metricsPort                            int32 = 8384
-	environmentVariableControllerNamespace       = "POD_NAMESPACE"
-	environmentVariableDatabaseURL               = "DATABASE_URL"
-	environmentVariableSyncInterval              = "HOH_STATUS_SYNC_INTERVAL"
+	defaultMetricsBindAddress              = "0.0.0.0:8384"
+	defaultHealthProbeBindAddress          = "0.0.0.0:8385"
+	defaultLeaderElectionID                = "hub-of-hubs-status-sync-lock"
+	environmentVariableConfigFile          = "HOH_STATUS_SYNC_CONFIG_FILE"
+	environmentVariableControllerNamespace = "POD_NAMESPACE"
+	environmentVariableDatabaseURL         = "DATABASE_URL"
+	environmentVariableSyncInterval        = "HOH_STATUS_SYNC_INTERVAL"
+	environmentVariableWorkerPoolSize      = "HOH_STATUS_SYNC_WORKER_POOL_SIZE"
+	environmentVariableSyncerConcurrency   = "HOH_STATUS_SYNC_SYNCER_CONCURRENCY"
+	environmentVariableEventDriven         = "HOH_STATUS_SYNC_EVENT_DRIVEN"
+	environmentVariableIncremental         = "HOH_STATUS_SYNC_INCREMENTAL"
+	environmentVariableResyncInterval      = "HOH_STATUS_SYNC_RESYNC_INTERVAL"
+	environmentVariableHealthTickMultiple  = "HOH_STATUS_SYNC_HEALTH_TICK_MULTIPLE"
+	environmentVariableShutdownGracePeriod = "HOH_STATUS_SYNC_SHUTDOWN_GRACE_PERIOD"
+	environmentVariableUnstructuredSyncers = "HOH_STATUS_SYNC_UNSTRUCTURED_SYNCERS_FILE"
+	environmentVariableGCInterval          = "HOH_STATUS_SYNC_GC_INTERVAL"
+	environmentVariableGCDryRun            = "HOH_STATUS_SYNC_GC_DRY_RUN"
+	environmentVariableStaleThreshold      = "HOH_STATUS_SYNC_STALE_LEAF_HUB_THRESHOLD"
+	environmentVariableStalePolicy         = "HOH_STATUS_SYNC_STALE_LEAF_HUB_POLICY"
+	environmentVariableDuplicateClusters   = "HOH_STATUS_SYNC_DUPLICATE_CLUSTERS"
+	environmentVariableQualifyClusterNames = "HOH_STATUS_SYNC_QUALIFY_CLUSTER_NAMES"
+	environmentVariableServerSideApply     = "HOH_STATUS_SYNC_SERVER_SIDE_APPLY"
+	environmentVariableForceOwnership      = "HOH_STATUS_SYNC_FORCE_OWNERSHIP"
+	environmentVariableWriteRetries        = "HOH_STATUS_SYNC_WRITE_RETRIES"
+	environmentVariableRetryInterval       = "HOH_STATUS_SYNC_WRITE_RETRY_INTERVAL"
+	environmentVariableRetryMaxInterval    = "HOH_STATUS_SYNC_WRITE_RETRY_MAX_INTERVAL"
+	environmentVariableDryRun              = "HOH_STATUS_SYNC_DRY_RUN"
+	environmentVariableDryRunFile          = "HOH_STATUS_SYNC_DRY_RUN_FILE"
+	syncerEnvironmentVariablePrefix        = "HOH_STATUS_SYNC_"
+	syncerEnabledSuffix                    = "_ENABLED"
+	syncerIntervalSuffix                   = "_INTERVAL"
+	syncerConcurrencySuffix                = "_CONCURRENCY"
+	dbSyncerNameSuffix                     = "-db-syncer"
+	defaultWorkerPoolSize                  = 40
+	defaultSyncerConcurrency               = 20
+	defaultResyncInterval                  = 5 * time.Minute
+	defaultShutdownGracePeriod             = 20 * time.Second
+	defaultWriteRetries                    = 5
+	defaultWriteRetryInterval              = 100 * time.Millisecond
+	defaultWriteRetryMaxInterval           = 5 * time.Second
+	gracefulShutdownTimeoutMargin          = 10 * time.Second
 )
 
 func printVersion(log logr.Logger) {
@@ -35,8 +72,64 @@ func printVersion(log logr.Logger) {
 	log.Info(fmt.Sprintf("Go OS/Arch: %s/%s", runtime.GOOS, runtime.GOARCH))
 }
 
+// syncerEnvironmentVariable returns the name of an environment variable of a DB syncer, for example
+// HOH_STATUS_SYNC_POLICIES_INTERVAL for the interval of policies-db-syncer.
+func syncerEnvironmentVariable(syncerName string, suffix string) string {
+	syncerID := strings.ReplaceAll(strings.ToUpper(strings.TrimSuffix(syncerName, dbSyncerNameSuffix)), "-", "_")
+
+	return syncerEnvironmentVariablePrefix + syncerID + suffix
+}
+
+// registerUnstructuredDBSyncers registers the DB syncers of the optional unstructured DB syncers configuration file.
+func registerUnstructuredDBSyncers(registry *dbsyncers.Registry, configFile string) error {
+	if configFile == "" {
+		return nil
+	}
+
+	unstructuredSyncersConfig, err := dbsyncers.ReadUnstructuredDBSyncersConfigFile(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to read unstructured DB syncers: %w", err)
+	}
+
+	for _, syncerConfig := range unstructuredSyncersConfig.Syncers {
+		if err := registry.Register(syncerConfig.Name,
+			dbsyncers.NewUnstructuredDBSyncerFactory(syncerConfig)); err != nil {
+			return fmt.Errorf("failed to register unstructured DB syncer: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// readConfig reads the configuration file and the environment variables that override it, registers the unstructured
+// DB syncers and validates the configuration.
+func readConfig(configFile string, registry *dbsyncers.Registry) (*managerConfig, error) {
+	config, err := readManagerConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := registerUnstructuredDBSyncers(registry, config.UnstructuredSyncersFile); err != nil {
+		return nil, err
+	}
+
+	if err := config.applySyncerEnvironmentOverrides(registry.Names()); err != nil {
+		return nil, err
+	}
+
+	if err := config.validate(registry.Names()); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
 // function to handle defers with exit, see https://stackoverflow.com/a/27629493/553720.
 func doMain() int {
+	once := pflag.Bool("once", false, "sync every enabled DB syncer once, print a summary and exit")
+	configFile := pflag.String("config", os.Getenv(environmentVariableConfigFile),
+		"path of the configuration file, overridden by the environment variables")
+
 	pflag.CommandLine.AddFlagSet(zap.FlagSet())
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 	pflag.Parse()
@@ -46,61 +139,101 @@ func doMain() int {
 
 	printVersion(log)
 
-	leaderElectionNamespace, found := os.LookupEnv(environmentVariableControllerNamespace)
-	if !found {
-		log.Error(nil, "Not found:", "environment variable", environmentVariableControllerNamespace)
-		return 1
-	}
+	dbSyncersRegistry := dbsyncers.NewDefaultRegistry()
 
-	databaseURL, found := os.LookupEnv(environmentVariableDatabaseURL)
-	if !found {
-		log.Error(nil, "Not found:", "environment variable", environmentVariableDatabaseURL)
-		return 1
-	}
-
-	syncIntervalString, found := os.LookupEnv(environmentVariableSyncInterval)
-	if !found {
-		log.Error(nil, "Not found:", "environment variable", environmentVariableSyncInterval)
-		return 1
-	}
-
-	syncInterval, err := time.ParseDuration(syncIntervalString)
+	config, err := readConfig(*configFile, dbSyncersRegistry)
 	if err != nil {
-		log.Error(err, "the environment var ", environmentVariableSyncInterval, " is not valid duration")
+		log.Error(err, "Failed to read the configuration")
 		return 1
 	}
 
 	// when switched to controller runtime 0.7, use the context returned by ctrl.SetupSignalHandler()
-	dbConnectionPool, err := pgxpool.Connect(context.TODO(), databaseURL)
+	dbConnectionPool, err := pgxpool.Connect(context.TODO(), config.Database.URL)
 	if err != nil {
 		log.Error(err, "Failed to connect to the database")
 		return 1
 	}
 	defer dbConnectionPool.Close()
 
-	mgr, err := createManager(leaderElectionNamespace, metricsHost, metricsPort, dbConnectionPool, syncInterval)
+	dbSyncersConfig := config.dbSyncersConfig()
+
+	if *once {
+		dbSyncersConfig.Once = dbsyncers.NewOnceReport()
+	} else if *configFile != "" { // the settings of the configuration file are reloaded once it changes
+		dbSyncersConfig.Reloader = dbsyncers.NewReloader()
+	}
+
+	mgr, err := createManager(config, dbsyncers.NewPostgreSQLStore(dbConnectionPool), dbSyncersRegistry,
+		dbSyncersConfig)
 	if err != nil {
 		log.Error(err, "Failed to create manager")
 		return 1
 	}
 
+	if dbSyncersConfig.Reloader != nil {
+		if err := mgr.Add(newConfigWatcher(ctrl.Log.WithName("config-watcher"), *configFile,
+			dbSyncersRegistry.Names(), dbSyncersConfig.Reloader, config)); err != nil {
+			log.Error(err, "Failed to add configuration watcher")
+			return 1
+		}
+	}
+
 	log.Info("Starting the Cmd.")
 
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	ctx, cancelContext := context.WithCancel(ctrl.SetupSignalHandler())
+	defer cancelContext()
+
+	if dbSyncersConfig.Once != nil {
+		go func() { // stop the manager once every DB syncer synced
+			select {
+			case <-dbSyncersConfig.Once.Done():
+				cancelContext()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	if err := mgr.Start(ctx); err != nil {
 		log.Error(err, "Manager exited non-zero")
 		return 1
 	}
 
+	if dbSyncersConfig.Once != nil {
+		return printOnceSummary(dbSyncersConfig.Once)
+	}
+
+	return 0
+}
+
+// printOnceSummary prints the summary of each DB syncer, returns a non-zero exit code if any sync failed or did not
+// complete.
+func printOnceSummary(report *dbsyncers.OnceReport) int {
+	for _, summary := range report.Summaries() {
+		fmt.Fprintf(os.Stdout, "%s: synced=%d skipped=%d failed=%d errors=%d\n", summary.Syncer, summary.Synced,
+			summary.Skipped, summary.Failed, summary.Errors)
+	}
+
+	if !report.Succeeded() {
+		fmt.Fprintln(os.Stdout, "sync failed or did not complete")
+		return 1
+	}
+
 	return 0
 }
 
-func createManager(leaderElectionNamespace, metricsHost string, metricsPort int32, dbConnectionPool *pgxpool.Pool,
-	syncInterval time.Duration) (ctrl.Manager, error) {
+func createManager(config *managerConfig, store dbsyncers.Store, dbSyncersRegistry *dbsyncers.Registry,
+	dbSyncersConfig *dbsyncers.Config) (ctrl.Manager, error) {
+	// the manager waits for the runnables beyond the grace period, for the abandoned handlers to return
+	gracefulShutdownTimeout := dbSyncersConfig.ShutdownGracePeriod + gracefulShutdownTimeoutMargin
+
 	options := ctrl.Options{
-		MetricsBindAddress:      fmt.Sprintf("%s:%d", metricsHost, metricsPort),
-		LeaderElection:          true,
-		LeaderElectionID:        "hub-of-hubs-status-sync-lock",
-		LeaderElectionNamespace: leaderElectionNamespace,
+		MetricsBindAddress:     config.Server.MetricsBindAddress,
+		HealthProbeBindAddress: config.Server.HealthProbeBindAddress,
+		// a one-shot sync does not wait for the leader
+		LeaderElection:          config.LeaderElection.Enabled && dbSyncersConfig.Once == nil,
+		LeaderElectionID:        config.LeaderElection.ID,
+		LeaderElectionNamespace: config.LeaderElection.Namespace,
+		GracefulShutdownTimeout: &gracefulShutdownTimeout,
 	}
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
@@ -112,7 +245,7 @@ func createManager(leaderElectionNamespace, metricsHost string, metricsPort int3
 		return nil, fmt.Errorf("failed to add schemes: %w", err)
 	}
 
-	if err := dbsyncers.AddDBSyncers(mgr, dbConnectionPool, syncInterval); err != nil {
+	if err := dbsyncers.AddDBSyncers(mgr, store, dbSyncersRegistry, dbSyncersConfig); err != nil {
 		return nil, fmt.Errorf("failed to add db syncers: %w", err)
 	}
 
@@ -0,0 +1,133 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stolostron/hub-of-hubs-status-sync/pkg/dbsyncers"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const configReloadInterval = 10 * time.Second
+
+// configWatcher polls the configuration file, e.g. mounted from a ConfigMap, and reloads the sync intervals, the
+// concurrency limits and the enabled DB syncers once it changes.
+type configWatcher struct {
+	log         logr.Logger
+	configFile  string
+	syncerNames []string
+	reloader    *dbsyncers.Reloader
+	// config is the configuration the DB syncers run with.
+	config *managerConfig
+	// lastError is the last reported error, so that an invalid file is not reported on every poll.
+	lastError string
+}
+
+func newConfigWatcher(log logr.Logger, configFile string, syncerNames []string, reloader *dbsyncers.Reloader,
+	config *managerConfig) *configWatcher {
+	return &configWatcher{
+		log:         log,
+		configFile:  configFile,
+		syncerNames: syncerNames,
+		reloader:    reloader,
+		config:      config,
+	}
+}
+
+// Start polls the configuration file until the context is cancelled.
+func (watcher *configWatcher) Start(ctx context.Context) error {
+	ticker := time.NewTicker(configReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done(): // we have received a signal to stop
+			return nil
+
+		case <-ticker.C:
+			watcher.reload()
+		}
+	}
+}
+
+// reload reads and validates the configuration file, and applies its reloadable settings if it changed. an invalid
+// configuration is reported and the current one is kept.
+func (watcher *configWatcher) reload() {
+	config, err := readManagerConfig(watcher.configFile)
+	if err == nil {
+		err = config.applySyncerEnvironmentOverrides(watcher.syncerNames)
+	}
+
+	if err == nil {
+		err = config.validate(watcher.syncerNames)
+	}
+
+	if err != nil {
+		if err.Error() != watcher.lastError {
+			watcher.lastError = err.Error()
+			watcher.log.Error(err, "failed to reload the configuration, keeping the current one")
+		}
+
+		return
+	}
+
+	watcher.lastError = ""
+
+	if reflect.DeepEqual(config, watcher.config) {
+		return
+	}
+
+	watcher.log.Info("configuration file changed, reloading", "file", watcher.configFile)
+
+	if sections := watcher.config.restartRequiredChanges(config); len(sections) > 0 {
+		watcher.log.Info("configuration changes require a restart to be applied", "sections", sections)
+	}
+
+	if err := watcher.reloader.Reload(config.dbSyncersConfig()); err != nil {
+		watcher.log.Error(err, "failed to reload the DB syncers")
+		return
+	}
+
+	watcher.config = config
+}
+
+// restartRequiredChanges returns the sections of the configuration that changed, ignoring the settings the DB syncers
+// reload.
+func (config *managerConfig) restartRequiredChanges(newConfig *managerConfig) []string {
+	oldSettings, newSettings := *config, *newConfig
+
+	for _, settings := range []*managerConfig{&oldSettings, &newSettings} {
+		settings.Sync.Interval = metav1.Duration{}
+		settings.Workers.SyncerConcurrency = 0
+		settings.Syncers = nil
+	}
+
+	sections := map[string][2]interface{}{
+		"database":                {oldSettings.Database, newSettings.Database},
+		"leaderElection":          {oldSettings.LeaderElection, newSettings.LeaderElection},
+		"server":                  {oldSettings.Server, newSettings.Server},
+		"workers":                 {oldSettings.Workers, newSettings.Workers},
+		"sync":                    {oldSettings.Sync, newSettings.Sync},
+		"leafHubs":                {oldSettings.LeafHubs, newSettings.LeafHubs},
+		"garbageCollection":       {oldSettings.GarbageCollection, newSettings.GarbageCollection},
+		"unstructuredSyncersFile": {oldSettings.UnstructuredSyncersFile, newSettings.UnstructuredSyncersFile},
+	}
+
+	var changedSections []string
+
+	for section, values := range sections {
+		if !reflect.DeepEqual(values[0], values[1]) {
+			changedSections = append(changedSections, section)
+		}
+	}
+
+	sort.Strings(changedSections)
+
+	return changedSections
+}
@@ -0,0 +1,247 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/spf13/pflag"
+	"github.com/stolostron/hub-of-hubs-status-sync/pkg/dbsyncers"
+
+	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	environmentVariableDatabaseURL         = "DATABASE_URL"
+	environmentVariableUnstructuredSyncers = "HOH_STATUS_SYNC_UNSTRUCTURED_SYNCERS_FILE"
+	environmentVariableStaleThreshold      = "HOH_STATUS_SYNC_STALE_LEAF_HUB_THRESHOLD"
+	environmentVariableStalePolicy         = "HOH_STATUS_SYNC_STALE_LEAF_HUB_POLICY"
+	environmentVariableDuplicateClusters   = "HOH_STATUS_SYNC_DUPLICATE_CLUSTERS"
+	environmentVariableQualifyClusterNames = "HOH_STATUS_SYNC_QUALIFY_CLUSTER_NAMES"
+	outputText                             = "text"
+	outputJSON                             = "json"
+	defaultTimeout                         = 5 * time.Minute
+	// exit codes, as of diff(1).
+	exitCodeNoChanges = 0
+	exitCodeChanges   = 1
+	exitCodeError     = 2
+)
+
+func usage(output io.Writer) {
+	fmt.Fprintln(output, `usage: status-sync diff [flags]
+
+Aggregates the statuses of the spec objects in the database as the DB syncers do, and prints the changes the DB
+syncers would make to the deployed CRs. Nothing is written. The database is read from the DATABASE_URL environment
+variable. Exits with 0 if there are no changes, 1 if there are changes and 2 on errors.`)
+}
+
+// registerUnstructuredDBSyncers registers the DB syncers of the optional unstructured DB syncers configuration file.
+func registerUnstructuredDBSyncers(registry *dbsyncers.Registry, configFile string) error {
+	if configFile == "" {
+		return nil
+	}
+
+	unstructuredSyncersConfig, err := dbsyncers.ReadUnstructuredDBSyncersConfigFile(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to read unstructured DB syncers: %w", err)
+	}
+
+	for _, syncerConfig := range unstructuredSyncersConfig.Syncers {
+		if err := registry.Register(syncerConfig.Name,
+			dbsyncers.NewUnstructuredDBSyncerFactory(syncerConfig)); err != nil {
+			return fmt.Errorf("failed to register unstructured DB syncer: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// diff returns the changes the DB syncers configured by syncersConfig would make to the CRs selected by the filter.
+func diff(ctx context.Context, databaseURL string, unstructuredSyncersFile string, syncersConfig *dbsyncers.Config,
+	filter *dbsyncers.DiffFilter) (*dbsyncers.DiffResult, error) {
+	registry := dbsyncers.NewDefaultRegistry()
+
+	if err := registerUnstructuredDBSyncers(registry, unstructuredSyncersFile); err != nil {
+		return nil, err
+	}
+
+	config, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get kubeconfig: %w", err)
+	}
+
+	// the manager is not started, it only creates the DB syncers
+	mgr, err := ctrl.NewManager(config, ctrl.Options{MetricsBindAddress: "0"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a new manager: %w", err)
+	}
+
+	if err := dbsyncers.AddToScheme(mgr.GetScheme()); err != nil {
+		return nil, fmt.Errorf("failed to add schemes: %w", err)
+	}
+
+	k8sClient, err := client.New(config, client.Options{Scheme: mgr.GetScheme(), Mapper: mgr.GetRESTMapper()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create k8s client: %w", err)
+	}
+
+	dbConnectionPool, err := pgxpool.Connect(ctx, databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the database: %w", err)
+	}
+	defer dbConnectionPool.Close()
+
+	result, err := dbsyncers.Diff(ctx, mgr, k8sClient, dbsyncers.NewPostgreSQLStore(dbConnectionPool), registry,
+		syncersConfig, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff: %w", err)
+	}
+
+	return result, nil
+}
+
+// setFlagsFromEnvironment sets the flags to the values of their environment variables, if set.
+func setFlagsFromEnvironment(flags *pflag.FlagSet, environmentVariables map[string]string) error {
+	for flagName, environmentVariable := range environmentVariables {
+		value, found := os.LookupEnv(environmentVariable)
+		if !found {
+			continue
+		}
+
+		if err := flags.Set(flagName, value); err != nil {
+			return fmt.Errorf("invalid environment variable %s: %w", environmentVariable, err)
+		}
+	}
+
+	return nil
+}
+
+func printText(output io.Writer, result *dbsyncers.DiffResult) error {
+	for _, change := range result.Changes {
+		target := change.Name
+		if change.Namespace != "" {
+			target = fmt.Sprintf("%s/%s", change.Namespace, change.Name)
+		}
+
+		if change.Subresource != "" {
+			target = fmt.Sprintf("%s (%s)", target, change.Subresource)
+		}
+
+		var indentedDiff bytes.Buffer
+		if err := json.Indent(&indentedDiff, change.Diff, "  ", "  "); err != nil {
+			return fmt.Errorf("failed to format diff: %w", err)
+		}
+
+		fmt.Fprintf(output, "%s would %s %s %s:\n  %s\n\n", change.Syncer, change.Operation, change.Kind, target,
+			indentedDiff.String())
+	}
+
+	fmt.Fprintf(output, "%d objects compared, %d changes\n", result.Compared, len(result.Changes))
+
+	return nil
+}
+
+func printJSON(output io.Writer, result *dbsyncers.DiffResult) error {
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(result); err != nil {
+		return fmt.Errorf("failed to encode diff: %w", err)
+	}
+
+	return nil
+}
+
+func doMain() int {
+	if len(os.Args) < 2 || os.Args[1] != "diff" {
+		usage(os.Stderr)
+		return exitCodeError
+	}
+
+	flags := pflag.NewFlagSet("diff", pflag.ContinueOnError)
+	filter := &dbsyncers.DiffFilter{}
+	flags.StringVar(&filter.Kind, "kind", "", "kind of the written CRs, e.g. Policy or PlacementDecision, all if empty")
+	flags.StringVar(&filter.Name, "name", "", "name of the spec objects, all names if empty")
+	flags.StringVarP(&filter.Namespace, "namespace", "n", "", "namespace of the spec objects, all if empty")
+	output := flags.StringP("output", "o", outputText, "output format, text or json")
+	timeout := flags.Duration("timeout", defaultTimeout, "timeout of the diff")
+	unstructuredSyncersFile := flags.String("unstructured-syncers-file", os.Getenv(environmentVariableUnstructuredSyncers),
+		"unstructured DB syncers configuration file")
+	syncersConfig := &dbsyncers.Config{}
+	flags.DurationVar(&syncersConfig.StaleLeafHubThreshold, "stale-leaf-hub-threshold", 0,
+		"age of the last heartbeat of stale leaf hubs, staleness is ignored if zero")
+	flags.StringVar((*string)(&syncersConfig.StaleLeafHubPolicy), "stale-leaf-hub-policy",
+		string(dbsyncers.StaleLeafHubPolicyDrop), "policy of the statuses of stale leaf hubs, drop or mark")
+	flags.StringVar((*string)(&syncersConfig.DuplicateClustersStrategy), "duplicate-clusters",
+		string(dbsyncers.DuplicateClustersKeepAll),
+		"strategy of the duplicate clusters, keepAll, preferMostRecent or qualify")
+	flags.BoolVar(&syncersConfig.QualifyClusterNames, "qualify-cluster-names", false,
+		"name the clusters by their leaf hub and name")
+
+	// the aggregation defaults to the one of the manager, as configured by its environment variables
+	if err := setFlagsFromEnvironment(flags, map[string]string{
+		"stale-leaf-hub-threshold": environmentVariableStaleThreshold,
+		"stale-leaf-hub-policy":    environmentVariableStalePolicy,
+		"duplicate-clusters":       environmentVariableDuplicateClusters,
+		"qualify-cluster-names":    environmentVariableQualifyClusterNames,
+	}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeError
+	}
+
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		usage(os.Stderr)
+		return exitCodeError
+	}
+
+	if *output != outputText && *output != outputJSON {
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", *output)
+		return exitCodeError
+	}
+
+	databaseURL, found := os.LookupEnv(environmentVariableDatabaseURL)
+	if !found {
+		fmt.Fprintf(os.Stderr, "environment variable %s is not set\n", environmentVariableDatabaseURL)
+		return exitCodeError
+	}
+
+	ctx, cancelContext := context.WithTimeout(context.Background(), *timeout)
+	defer cancelContext()
+
+	result, err := diff(ctx, databaseURL, *unstructuredSyncersFile, syncersConfig, filter)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeError
+	}
+
+	printResult := printText
+	if *output == outputJSON {
+		printResult = printJSON
+	}
+
+	if err := printResult(os.Stdout, result); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeError
+	}
+
+	if len(result.Changes) > 0 {
+		return exitCodeChanges
+	}
+
+	return exitCodeNoChanges
+}
+
+func main() {
+	os.Exit(doMain())
+}
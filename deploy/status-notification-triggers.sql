@@ -0,0 +1,77 @@
+-- Copyright Contributors to the Open Cluster Management project
+
+-- Triggers that announce changes of the status tables for the event-driven mode (HOH_STATUS_SYNC_EVENT_DRIVEN=true).
+-- The channel of each table is status_<table name>, the payload is the key of the affected spec object.
+-- The triggers below cover the status tables of the built-in DB syncers. The DB syncers configured by
+-- HOH_STATUS_SYNC_UNSTRUCTURED_SYNCERS_FILE need a trigger on their own status table, keyed by namespace and name:
+--
+--   DROP TRIGGER IF EXISTS notify_change ON status.<status table>;
+--   CREATE TRIGGER notify_change AFTER INSERT OR UPDATE OR DELETE ON status.<status table>
+--       FOR EACH ROW EXECUTE FUNCTION status.notify_namespaced_name_change();
+
+CREATE OR REPLACE FUNCTION status.notify_compliance_change() RETURNS trigger AS $$
+DECLARE
+    changed_row RECORD;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        changed_row := OLD;
+    ELSE
+        changed_row := NEW;
+    END IF;
+
+    -- the key of a policy is its id
+    PERFORM pg_notify('status_' || TG_TABLE_NAME, changed_row.id::text);
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- the key of the other objects is <namespace>/<name>. if a label is passed as the trigger argument, the name is taken
+-- from the label (e.g. placement-decisions are named after their placement with a suffix).
+CREATE OR REPLACE FUNCTION status.notify_namespaced_name_change() RETURNS trigger AS $$
+DECLARE
+    changed_row RECORD;
+    object_name TEXT;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        changed_row := OLD;
+    ELSE
+        changed_row := NEW;
+    END IF;
+
+    IF TG_NARGS > 0 THEN
+        object_name := changed_row.payload->'metadata'->'labels'->>TG_ARGV[0];
+    ELSE
+        object_name := changed_row.payload->'metadata'->>'name';
+    END IF;
+
+    PERFORM pg_notify('status_' || TG_TABLE_NAME,
+        (changed_row.payload->'metadata'->>'namespace') || '/' || object_name);
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS notify_change ON status.compliance;
+CREATE TRIGGER notify_change AFTER INSERT OR UPDATE OR DELETE ON status.compliance
+    FOR EACH ROW EXECUTE FUNCTION status.notify_compliance_change();
+
+DROP TRIGGER IF EXISTS notify_change ON status.placements;
+CREATE TRIGGER notify_change AFTER INSERT OR UPDATE OR DELETE ON status.placements
+    FOR EACH ROW EXECUTE FUNCTION status.notify_namespaced_name_change();
+
+DROP TRIGGER IF EXISTS notify_change ON status.placementdecisions;
+CREATE TRIGGER notify_change AFTER INSERT OR UPDATE OR DELETE ON status.placementdecisions
+    FOR EACH ROW EXECUTE FUNCTION status.notify_namespaced_name_change('cluster.open-cluster-management.io/placement');
+
+DROP TRIGGER IF EXISTS notify_change ON status.placementrules;
+CREATE TRIGGER notify_change AFTER INSERT OR UPDATE OR DELETE ON status.placementrules
+    FOR EACH ROW EXECUTE FUNCTION status.notify_namespaced_name_change();
+
+DROP TRIGGER IF EXISTS notify_change ON status.subscription_statuses;
+CREATE TRIGGER notify_change AFTER INSERT OR UPDATE OR DELETE ON status.subscription_statuses
+    FOR EACH ROW EXECUTE FUNCTION status.notify_namespaced_name_change();
+
+DROP TRIGGER IF EXISTS notify_change ON status.subscription_reports;
+CREATE TRIGGER notify_change AFTER INSERT OR UPDATE OR DELETE ON status.subscription_reports
+    FOR EACH ROW EXECUTE FUNCTION status.notify_namespaced_name_change();
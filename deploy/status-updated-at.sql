@@ -0,0 +1,67 @@
+-- Copyright Contributors to the Open Cluster Management project
+
+-- Maintains the updated_at column of the status tables for the incremental mode (HOH_STATUS_SYNC_INCREMENTAL=true),
+-- and records the deleted status rows in status.deleted_status_rows so that the incremental syncs also see them.
+
+-- clock_timestamp() rather than now(), which is the start time of the transaction: a long transaction would otherwise
+-- commit rows older than the watermark of the DB syncers. the DB syncers also re-read a few seconds below the
+-- watermark, for the rows of the transactions that commit after a later one.
+CREATE OR REPLACE FUNCTION status.set_updated_at() RETURNS trigger AS $$
+BEGIN
+    NEW.updated_at := clock_timestamp();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- the key expressions of the DB syncers only read the id and payload columns, which are kept for the deleted rows.
+CREATE TABLE IF NOT EXISTS status.deleted_status_rows (
+    table_name TEXT NOT NULL,
+    id UUID,
+    payload JSONB,
+    deleted_at TIMESTAMPTZ NOT NULL DEFAULT clock_timestamp()
+);
+
+CREATE INDEX IF NOT EXISTS deleted_status_rows_deleted_at_idx ON status.deleted_status_rows (deleted_at);
+
+CREATE OR REPLACE FUNCTION status.record_deleted_status_row() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO status.deleted_status_rows (table_name, id, payload)
+        VALUES (TG_TABLE_NAME, (to_jsonb(OLD)->>'id')::uuid, to_jsonb(OLD)->'payload');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- the deleted rows are only needed until the next full resync of the DB syncers, keep them for a day.
+CREATE OR REPLACE FUNCTION status.purge_deleted_status_rows() RETURNS trigger AS $$
+BEGIN
+    DELETE FROM status.deleted_status_rows WHERE deleted_at < clock_timestamp() - interval '1 day';
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DO $$
+DECLARE
+    table_name TEXT;
+BEGIN
+    FOREACH table_name IN ARRAY ARRAY['compliance', 'placements', 'placementdecisions', 'placementrules',
+        'subscription_statuses', 'subscription_reports']
+    LOOP
+        EXECUTE format('ALTER TABLE status.%I ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL
+            DEFAULT clock_timestamp()', table_name);
+        -- a column added by a previous version of this script is a TIMESTAMP set by now()
+        EXECUTE format('ALTER TABLE status.%I ALTER COLUMN updated_at TYPE TIMESTAMPTZ,
+            ALTER COLUMN updated_at SET DEFAULT clock_timestamp()', table_name);
+        EXECUTE format('CREATE INDEX IF NOT EXISTS %I ON status.%I (updated_at)', table_name || '_updated_at_idx',
+            table_name);
+        EXECUTE format('DROP TRIGGER IF EXISTS set_updated_at ON status.%I', table_name);
+        EXECUTE format('CREATE TRIGGER set_updated_at BEFORE INSERT OR UPDATE ON status.%I
+            FOR EACH ROW EXECUTE FUNCTION status.set_updated_at()', table_name);
+        EXECUTE format('DROP TRIGGER IF EXISTS record_deleted_row ON status.%I', table_name);
+        EXECUTE format('CREATE TRIGGER record_deleted_row AFTER DELETE ON status.%I
+            FOR EACH ROW EXECUTE FUNCTION status.record_deleted_status_row()', table_name);
+        EXECUTE format('DROP TRIGGER IF EXISTS purge_deleted_rows ON status.%I', table_name);
+        EXECUTE format('CREATE TRIGGER purge_deleted_rows AFTER DELETE ON status.%I
+            FOR EACH STATEMENT EXECUTE FUNCTION status.purge_deleted_status_rows()', table_name);
+    END LOOP;
+END;
+$$;
@@ -0,0 +1,92 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"fmt"
+	"time"
+)
+
+// aggregationOptions configures the handling of the statuses reported by the leaf hubs before their aggregation.
+type aggregationOptions struct {
+	// staleLeafHubs are handled by staleLeafHubPolicy, none if staleness is ignored.
+	staleLeafHubs             map[string]struct{}
+	staleLeafHubPolicy        StaleLeafHubPolicy
+	duplicateClustersStrategy DuplicateClustersStrategy
+	// leafHubUpdateTimes are the update times of the status rows of each object by leaf hub, by the object key. only
+	// used by preferMostRecent.
+	leafHubUpdateTimes  map[string]map[string]time.Time
+	qualifyClusterNames bool
+}
+
+// objectAggregation is the aggregated status of a spec object.
+type objectAggregation struct {
+	// status is nil if there is nothing to apply.
+	status interface{}
+	// staleLeafHubNames are the sorted stale leaf hubs that reported a status of the object.
+	staleLeafHubNames []string
+	// conflictingClusters are the sorted clusters reported by more than one leaf hub.
+	conflictingClusters []string
+}
+
+// validateAggregationConfig checks the stale leaf hub policy and the duplicate clusters strategy of the config.
+func validateAggregationConfig(config *Config) error {
+	if config.StaleLeafHubThreshold > 0 && config.StaleLeafHubPolicy != StaleLeafHubPolicyDrop &&
+		config.StaleLeafHubPolicy != StaleLeafHubPolicyMark {
+		return fmt.Errorf("%w: unknown stale leaf hub policy %q", errInvalidConfiguration, config.StaleLeafHubPolicy)
+	}
+
+	switch config.DuplicateClustersStrategy {
+	case DuplicateClustersKeepAll, DuplicateClustersPreferMostRecent, DuplicateClustersQualify:
+	default:
+		return fmt.Errorf("%w: unknown duplicate clusters strategy %q", errInvalidConfiguration,
+			config.DuplicateClustersStrategy)
+	}
+
+	return nil
+}
+
+// effectiveDuplicateClustersStrategy returns keepAll instead of qualify if every cluster name is qualified anyway, the
+// duplicates are then only detected.
+func effectiveDuplicateClustersStrategy(strategy DuplicateClustersStrategy,
+	qualifyClusterNames bool) DuplicateClustersStrategy {
+	if qualifyClusterNames && strategy == DuplicateClustersQualify {
+		return DuplicateClustersKeepAll
+	}
+
+	return strategy
+}
+
+// aggregateObjectStatus aggregates the valid statuses reported by the leaf hubs for a spec object, once the statuses
+// of the stale leaf hubs are handled, the duplicate clusters are resolved and the cluster names are qualified. it is
+// shared by the DB syncers and Diff, so that both aggregate the same status.
+func aggregateObjectStatus(dbSyncer DBSyncer, object *SpecObject, leafHubStatuses []*LeafHubStatus,
+	options *aggregationOptions) *objectAggregation {
+	leafHubStatuses, staleLeafHubNames := handleStaleLeafHubStatuses(dbSyncer, options.staleLeafHubPolicy,
+		options.staleLeafHubs, leafHubStatuses)
+
+	var duplicateClusters []string
+
+	if lister, ok := dbSyncer.(ClusterListingDBSyncer); ok {
+		leafHubStatuses, duplicateClusters = resolveDuplicateClusters(lister, options.duplicateClustersStrategy,
+			leafHubStatuses, options.leafHubUpdateTimes[object.Key])
+	}
+
+	if options.qualifyClusterNames {
+		leafHubStatuses = qualifyClusterNames(dbSyncer, leafHubStatuses)
+	}
+
+	aggregatedStatus, conflictingClusters := dbSyncer.Aggregate(object, leafHubStatuses)
+
+	if annotator, ok := dbSyncer.(LeafHubAnnotatingDBSyncer); ok && options.qualifyClusterNames &&
+		aggregatedStatus != nil {
+		annotator.AnnotateLeafHubs(aggregatedStatus, leafHubStatuses)
+	}
+
+	return &objectAggregation{
+		status:              aggregatedStatus,
+		staleLeafHubNames:   staleLeafHubNames,
+		conflictingClusters: sortedUnion(duplicateClusters, conflictingClusters),
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+// LeafHubAnnotatingDBSyncer is implemented by the DBSyncers whose statuses do not list clusters, so that the leaf hub
+// of each aggregated entry is surfaced in a companion annotation when the cluster names are qualified.
+type LeafHubAnnotatingDBSyncer interface {
+	// AnnotateLeafHubs annotates the aggregated status with the leaf hub of each of its entries.
+	AnnotateLeafHubs(aggregatedStatus interface{}, leafHubStatuses []*LeafHubStatus)
+}
+
+// qualifyClusterNames returns the statuses with every cluster named by qualifiedClusterName, if the DBSyncer lists
+// clusters.
+func qualifyClusterNames(dbSyncer DBSyncer, leafHubStatuses []*LeafHubStatus) []*LeafHubStatus {
+	lister, ok := dbSyncer.(ClusterListingDBSyncer)
+	if !ok {
+		return leafHubStatuses
+	}
+
+	qualifiedStatuses := make([]*LeafHubStatus, 0, len(leafHubStatuses))
+
+	for _, leafHubStatus := range leafHubStatuses {
+		leafHubName := leafHubStatus.LeafHubName
+
+		qualifiedStatuses = append(qualifiedStatuses, &LeafHubStatus{
+			LeafHubName: leafHubName,
+			Status: lister.RewriteClusters(leafHubStatus.Status, func(clusterName string) (string, bool) {
+				return qualifiedClusterName(leafHubName, clusterName), true
+			}),
+		})
+	}
+
+	return qualifiedStatuses
+}
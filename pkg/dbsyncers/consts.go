@@ -1,11 +1,36 @@
 package dbsyncers
 
+// DB Syncers names.
+const (
+	policiesDBSyncerName             = "policies-db-syncer"
+	placementRulesDBSyncerName       = "placementrule-db-syncer"
+	placementsDBSyncerName           = "placement-db-syncer"
+	placementDecisionsDBSyncerName   = "placement-decisions-db-syncer"
+	subscriptionStatusesDBSyncerName = "subscription-statuses-db-syncer"
+	subscriptionReportsDBSyncerName  = "subscription-reports-db-syncer"
+)
+
 // API Info.
 const (
 	appsv1APIGroup          = "apps.open-cluster-management.io/v1"
+	appsv1alpha1APIGroup    = "apps.open-cluster-management.io/v1alpha1"
 	clustersv1beta1APIGroup = "cluster.open-cluster-management.io/v1beta1"
 	placementKind           = "Placement"
+	placementDecisionKind   = "PlacementDecision"
+	policyKind              = "Policy"
+	placementRuleKind       = "PlacementRule"
 	subscriptionKind        = "Subscription"
+	subscriptionStatusKind  = "SubscriptionStatus"
+	subscriptionReportKind  = "SubscriptionReport"
+)
+
+// Labels.
+const (
+	// statusSyncLabelKey marks the CRs created by the DB syncers and the spec objects whose status they wrote, for the
+	// garbage collection.
+	statusSyncLabelKey       = "hub-of-hubs.open-cluster-management.io/status-sync"
+	statusSyncLabelValue     = "true"
+	replicatedPolicyLabelKey = "policy.open-cluster-management.io/root-policy"
 )
 
 // DB Tables.
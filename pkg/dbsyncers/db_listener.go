@@ -0,0 +1,175 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	notificationsBufferSize = 1000
+	listenRetryInterval     = 5 * time.Second
+)
+
+// statusNotificationChannel returns the notification channel on which changes of a status table are announced.
+func statusNotificationChannel(statusTableName string) string {
+	return fmt.Sprintf("status_%s", statusTableName)
+}
+
+// dbListener listens on PostgreSQL notification channels and dispatches the notifications to the DB syncers.
+// the notifications are expected to be emitted by the triggers in deploy/status-notification-triggers.sql.
+type dbListener struct {
+	log                    logr.Logger
+	databaseConnectionPool *pgxpool.Pool
+	// subscriptions holds the subscriptions of each channel, DB syncers of the same status table share a channel.
+	subscriptions map[string][]*dbNotificationSubscription
+}
+
+// dbNotificationSubscription delivers the keys of changed objects to a DB syncer. if notifications may have been
+// lost (the connection was broken or the buffer overflowed), a full resync is requested instead.
+type dbNotificationSubscription struct {
+	keys   chan string
+	resync chan struct{}
+}
+
+func newDBListener(log logr.Logger, databaseConnectionPool *pgxpool.Pool) *dbListener {
+	return &dbListener{
+		log:                    log,
+		databaseConnectionPool: databaseConnectionPool,
+		subscriptions:          map[string][]*dbNotificationSubscription{},
+	}
+}
+
+// subscribe returns a subscription to a notification channel. must be called before the listener is started.
+// a nil listener (polling mode) returns a nil subscription.
+func (listener *dbListener) subscribe(channel string) *dbNotificationSubscription {
+	if listener == nil {
+		return nil
+	}
+
+	subscription := &dbNotificationSubscription{
+		keys:   make(chan string, notificationsBufferSize),
+		resync: make(chan struct{}, 1),
+	}
+	listener.subscriptions[channel] = append(listener.subscriptions[channel], subscription)
+
+	return subscription
+}
+
+// Start listens for notifications until the context is cancelled, reconnecting on failures.
+func (listener *dbListener) Start(ctx context.Context) error {
+	for {
+		err := listener.listen(ctx)
+
+		if ctx.Err() != nil { // we have received a signal to stop
+			return nil
+		}
+
+		listener.log.Error(err, "failed to listen for DB notifications, retrying", "interval", listenRetryInterval)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(listenRetryInterval):
+		}
+	}
+}
+
+func (listener *dbListener) listen(ctx context.Context) error {
+	conn, err := listener.databaseConnectionPool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire DB connection - %w", err)
+	}
+
+	defer conn.Release()
+
+	for channel := range listener.subscriptions {
+		if _, err := conn.Exec(ctx, fmt.Sprintf("LISTEN %s", pgx.Identifier{channel}.Sanitize())); err != nil {
+			return fmt.Errorf("failed to listen on channel %s - %w", channel, err)
+		}
+	}
+
+	listener.log.Info("listening for DB notifications", "channels", len(listener.subscriptions))
+
+	// notifications sent before LISTEN was executed are lost
+	for _, subscriptions := range listener.subscriptions {
+		for _, subscription := range subscriptions {
+			subscription.requestResync()
+		}
+	}
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to wait for DB notification - %w", err)
+		}
+
+		listener.dispatch(notification.Channel, notification.Payload)
+	}
+}
+
+// dispatch delivers the key of a changed object to every subscription of the channel.
+func (listener *dbListener) dispatch(channel string, key string) {
+	for _, subscription := range listener.subscriptions[channel] {
+		subscription.notify(key)
+	}
+}
+
+// notify delivers the key of a changed object, or requests a resync if the DB syncer is lagging behind.
+func (subscription *dbNotificationSubscription) notify(key string) {
+	select {
+	case subscription.keys <- key:
+	default: // the syncer is lagging behind, let it do a full resync instead
+		subscription.requestResync()
+	}
+}
+
+func (subscription *dbNotificationSubscription) requestResync() {
+	select {
+	case subscription.resync <- struct{}{}:
+	default: // resync is already pending
+	}
+}
+
+// keysChannel returns the channel of changed keys, nil subscription returns a nil channel that blocks forever.
+func (subscription *dbNotificationSubscription) keysChannel() <-chan string {
+	if subscription == nil {
+		return nil
+	}
+
+	return subscription.keys
+}
+
+// resyncChannel returns the channel of resync requests, nil subscription returns a nil channel that blocks forever.
+func (subscription *dbNotificationSubscription) resyncChannel() <-chan struct{} {
+	if subscription == nil {
+		return nil
+	}
+
+	return subscription.resync
+}
+
+// drainKeys returns the given key together with all the keys already buffered in the subscription, deduplicated.
+func (subscription *dbNotificationSubscription) drainKeys(firstKey string) []string {
+	keysSet := map[string]struct{}{firstKey: {}}
+	keys := []string{firstKey}
+
+	for {
+		select {
+		case key := <-subscription.keys:
+			if _, found := keysSet[key]; !found {
+				keysSet[key] = struct{}{}
+				keys = append(keys, key)
+			}
+		default:
+			return keys
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"context"
+	"errors"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	errUnexpectedStatusType = errors.New("unexpected aggregated status type")
+	errUnexpectedObjectType = errors.New("unexpected object type")
+)
+
+// SpecObject identifies an object of a spec table whose status is synced.
+type SpecObject struct {
+	// Key identifies the object in the status tables, see DBSyncer.StatusTable.
+	Key string
+	// UID is the id of the spec row, which is also the UID of the object.
+	UID       string
+	Name      string
+	Namespace string
+}
+
+// LeafHubStatus is a status reported by a leaf hub.
+type LeafHubStatus struct {
+	LeafHubName string
+	Status      interface{}
+	// Err is set instead of Status if the status could not be read, e.g. its payload is not valid. such statuses are
+	// reported and skipped before the aggregation.
+	Err error
+}
+
+// DBSyncer syncs the statuses of one kind of objects, as reported by the leaf hubs in a status table, to the CRs.
+// the periodic sync, the concurrency, the metrics and the Events are handled by AddDBSyncers.
+type DBSyncer interface {
+	// SpecGroupVersionKind returns the kind of the spec objects, the Events of the DBSyncer are recorded on them.
+	SpecGroupVersionKind() schema.GroupVersionKind
+	// StatusTable returns the name of the status table and an SQL expression that evaluates to the key of the spec
+	// object a status row belongs to. used by the event-driven and incremental modes to tell which objects to sync.
+	StatusTable() (tableName string, keyExpression string)
+	// ListSpecObjects returns the spec objects to sync, if keys is not nil only those whose key is one of the keys.
+	ListSpecObjects(ctx context.Context, specStore SpecStore, keys []string) ([]*SpecObject, error)
+	// FetchStatuses returns the statuses reported by the leaf hubs for the given spec objects, by the object key.
+	FetchStatuses(ctx context.Context, statusStore StatusStore,
+		objects []*SpecObject) (map[string][]*LeafHubStatus, error)
+	// Aggregate returns the aggregated status of a spec object, nil if there is nothing to apply, and the names of
+	// the clusters reported by more than one leaf hub.
+	Aggregate(object *SpecObject, leafHubStatuses []*LeafHubStatus) (aggregatedStatus interface{},
+		conflictingClusters []string)
+	// Apply applies the aggregated status to the CRs, returns whether any CR was changed.
+	Apply(ctx context.Context, k8sClient client.Client, object *SpecObject, aggregatedStatus interface{}) (bool, error)
+}
+
+// TargetingDBSyncer is implemented by the DBSyncers that write the aggregated statuses to CRs of another kind than
+// their spec objects, e.g. to CRs they generate.
+type TargetingDBSyncer interface {
+	DBSyncer
+	// TargetGroupVersionKind returns the kind of the CRs the aggregated statuses are written to.
+	TargetGroupVersionKind() schema.GroupVersionKind
+}
+
+// targetGroupVersionKind returns the kind of the CRs the DBSyncer writes the aggregated statuses to.
+func targetGroupVersionKind(dbSyncer DBSyncer) schema.GroupVersionKind {
+	if targetingDBSyncer, ok := dbSyncer.(TargetingDBSyncer); ok {
+		return targetingDBSyncer.TargetGroupVersionKind()
+	}
+
+	return dbSyncer.SpecGroupVersionKind()
+}
+
+// SyncedObject is a CR a DBSyncer wrote to, that may have to be garbage collected.
+type SyncedObject struct {
+	// Key is the key of the spec object the CR was written for, see SpecObject.Key.
+	Key    string
+	Object client.Object
+	// OwnerUID is the UID of the controller owner of a generated CR, empty for the status of a spec object.
+	OwnerUID string
+}
+
+// GarbageCollectedDBSyncer is a DBSyncer whose CRs are garbage collected once their spec objects are deleted or
+// missing, or once a generated CR is owned by an object that is not the spec object.
+type GarbageCollectedDBSyncer interface {
+	DBSyncer
+	// ListSyncedObjects returns the CRs the DBSyncer may have written to, generated CRs are expected to be selected
+	// by the status sync label.
+	ListSyncedObjects(ctx context.Context, k8sReader client.Reader) ([]*SyncedObject, error)
+	// Collect deletes a generated CR or clears the status of a spec object.
+	Collect(ctx context.Context, k8sClient client.Client, object client.Object) error
+}
@@ -0,0 +1,996 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	policiesv1 "github.com/open-cluster-management/governance-policy-propagator/api/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clustersv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+	placementrulesv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/placementrule/v1"
+	appsv1alpha1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const (
+	testNamespace = "default"
+	testUID       = "9a3f0a3c-7b3e-4c5b-9d55-0e8cb6b1d2a1"
+)
+
+// testStatusRow is a status row of a test case, its payload is marshaled from the object.
+type testStatusRow struct {
+	leafHubName string
+	object      interface{}
+	clusterName string
+	compliance  string
+}
+
+// dbSyncerTestCase syncs a single spec object named name in testNamespace.
+type dbSyncerTestCase struct {
+	name string
+	// objects are deployed before the sync.
+	objects    []client.Object
+	statusRows []testStatusRow
+	// wantStatuses is the number of statuses FetchStatuses returns for the spec object.
+	wantStatuses int
+	// wantApplied is whether Aggregate returns a status to apply.
+	wantApplied bool
+	wantPatched bool
+	// staleLeafHubs are handled by staleLeafHubPolicy before the aggregation.
+	staleLeafHubs      map[string]struct{}
+	staleLeafHubPolicy StaleLeafHubPolicy
+	// wantStaleLeafHubs is the stale leaf hubs annotation of the deployed spec object, checked if not empty.
+	wantStaleLeafHubs string
+	check             func(t *testing.T, k8sClient client.Client)
+}
+
+// runDBSyncerTestCase seeds a MemoryStore with the spec and status rows, and runs FetchStatuses, the aggregation of
+// the DB syncers and Apply.
+func runDBSyncerTestCase(t *testing.T, dbSyncer DBSyncer, specTableName string, testCase *dbSyncerTestCase) {
+	t.Helper()
+
+	ctx := context.Background()
+	store := NewMemoryStore()
+	statusTableName, _ := dbSyncer.StatusTable()
+
+	store.AddSpecRow(specTableName, &MemorySpecRow{
+		ID:      testUID,
+		Payload: marshalPayload(t, map[string]interface{}{"metadata": objectMeta("spec")}),
+	})
+
+	for _, statusRow := range testCase.statusRows {
+		row := &MemoryStatusRow{
+			LeafHubName: statusRow.leafHubName,
+			ClusterName: statusRow.clusterName,
+			Compliance:  statusRow.compliance,
+		}
+
+		if statusRow.object != nil {
+			row.Payload = marshalPayload(t, statusRow.object)
+		} else {
+			row.ID = testUID
+		}
+
+		store.AddStatusRow(statusTableName, row)
+	}
+
+	k8sClient := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(testCase.objects...).Build()
+
+	objects, err := dbSyncer.ListSpecObjects(ctx, store, nil)
+	if err != nil {
+		t.Fatalf("failed to list spec objects: %v", err)
+	}
+
+	if len(objects) != 1 || objects[0].Name != "spec" || objects[0].Namespace != testNamespace {
+		t.Fatalf("unexpected spec objects: %+v", objects)
+	}
+
+	leafHubStatuses, err := dbSyncer.FetchStatuses(ctx, store, objects)
+	if err != nil {
+		t.Fatalf("failed to fetch statuses: %v", err)
+	}
+
+	if got := len(leafHubStatuses[objects[0].Key]); got != testCase.wantStatuses {
+		t.Fatalf("FetchStatuses returned %d statuses, want %d", got, testCase.wantStatuses)
+	}
+
+	aggregation := aggregateObjectStatus(dbSyncer, objects[0], leafHubStatuses[objects[0].Key], &aggregationOptions{
+		staleLeafHubs:             testCase.staleLeafHubs,
+		staleLeafHubPolicy:        testCase.staleLeafHubPolicy,
+		duplicateClustersStrategy: DuplicateClustersKeepAll,
+	})
+	aggregatedStatus := aggregation.status
+
+	if (aggregatedStatus != nil) != testCase.wantApplied {
+		t.Fatalf("Aggregate returned %+v, want a status to apply: %t", aggregatedStatus, testCase.wantApplied)
+	}
+
+	if aggregatedStatus == nil {
+		return
+	}
+
+	patched, err := dbSyncer.Apply(ctx, k8sClient, objects[0], aggregatedStatus)
+	if err != nil {
+		t.Fatalf("failed to apply status: %v", err)
+	}
+
+	if patched != testCase.wantPatched {
+		t.Errorf("Apply returned patched %t, want %t", patched, testCase.wantPatched)
+	}
+
+	if testCase.wantStaleLeafHubs != "" {
+		checkStaleLeafHubsAnnotation(t, k8sClient, dbSyncer, objects[0], aggregation.staleLeafHubNames,
+			testCase.wantStaleLeafHubs)
+	}
+
+	if testCase.check != nil {
+		testCase.check(t, k8sClient)
+	}
+}
+
+// checkStaleLeafHubsAnnotation annotates the spec object with the stale leaf hubs and checks its annotation.
+func checkStaleLeafHubsAnnotation(t *testing.T, k8sClient client.Client, dbSyncer DBSyncer, object *SpecObject,
+	staleLeafHubNames []string, wantStaleLeafHubs string) {
+	t.Helper()
+
+	gvk := dbSyncer.SpecGroupVersionKind()
+	target := eventTarget(gvk.GroupVersion().String(), gvk.Kind, object.Name, object.Namespace, object.UID)
+
+	if err := updateStaleLeafHubsAnnotation(context.Background(), k8sClient, k8sClient, target,
+		staleLeafHubNames); err != nil {
+		t.Fatalf("failed to annotate stale leaf hubs: %v", err)
+	}
+
+	deployedObject := &metav1.PartialObjectMetadata{}
+	deployedObject.SetGroupVersionKind(gvk)
+	getObject(t, k8sClient, object.Name, deployedObject)
+
+	if annotation := deployedObject.GetAnnotations()[staleLeafHubsAnnotation]; annotation != wantStaleLeafHubs {
+		t.Errorf("stale leaf hubs annotation is %q, want %q", annotation, wantStaleLeafHubs)
+	}
+}
+
+func staleLeafHubs(leafHubNames ...string) map[string]struct{} {
+	staleLeafHubs := make(map[string]struct{}, len(leafHubNames))
+	for _, leafHubName := range leafHubNames {
+		staleLeafHubs[leafHubName] = struct{}{}
+	}
+
+	return staleLeafHubs
+}
+
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to add to scheme: %v", err)
+	}
+
+	return scheme
+}
+
+func marshalPayload(t *testing.T, object interface{}) json.RawMessage {
+	t.Helper()
+
+	payload, err := json.Marshal(object)
+	if err != nil {
+		t.Fatalf("failed to marshal payload: %v", err)
+	}
+
+	return payload
+}
+
+func objectMeta(name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Name: name, Namespace: testNamespace}
+}
+
+func getObject(t *testing.T, k8sClient client.Client, name string, object client.Object) {
+	t.Helper()
+
+	if err := k8sClient.Get(context.Background(), client.ObjectKey{Name: name, Namespace: testNamespace},
+		object); err != nil {
+		t.Fatalf("failed to get %s: %v", name, err)
+	}
+}
+
+func TestPolicyDBSyncer(t *testing.T) {
+	policy := &policiesv1.Policy{ObjectMeta: objectMeta("spec")}
+
+	testCases := []*dbSyncerTestCase{
+		{
+			name:    "non-compliant cluster",
+			objects: []client.Object{policy.DeepCopy()},
+			statusRows: []testStatusRow{
+				{leafHubName: "hub1", clusterName: "cluster1", compliance: dbEnumCompliant},
+				{leafHubName: "hub2", clusterName: "cluster2", compliance: dbEnumNonCompliant},
+			},
+			wantStatuses: 2,
+			wantApplied:  true,
+			wantPatched:  true,
+			check: func(t *testing.T, k8sClient client.Client) {
+				t.Helper()
+
+				deployedPolicy := &policiesv1.Policy{}
+				getObject(t, k8sClient, "spec", deployedPolicy)
+
+				if deployedPolicy.Status.ComplianceState != policiesv1.NonCompliant {
+					t.Errorf("compliance state is %q, want %q", deployedPolicy.Status.ComplianceState,
+						policiesv1.NonCompliant)
+				}
+
+				if len(deployedPolicy.Status.Status) != 2 || deployedPolicy.Status.Status[1].ClusterName != "cluster2" {
+					t.Errorf("unexpected compliance per cluster: %+v", deployedPolicy.Status.Status)
+				}
+			},
+		},
+		{
+			name:    "compliant clusters",
+			objects: []client.Object{policy.DeepCopy()},
+			statusRows: []testStatusRow{
+				{leafHubName: "hub1", clusterName: "cluster1", compliance: dbEnumCompliant},
+			},
+			wantStatuses: 1,
+			wantApplied:  true,
+			wantPatched:  true,
+			check: func(t *testing.T, k8sClient client.Client) {
+				t.Helper()
+
+				deployedPolicy := &policiesv1.Policy{}
+				getObject(t, k8sClient, "spec", deployedPolicy)
+
+				if deployedPolicy.Status.ComplianceState != policiesv1.Compliant {
+					t.Errorf("compliance state is %q, want %q", deployedPolicy.Status.ComplianceState,
+						policiesv1.Compliant)
+				}
+			},
+		},
+		{
+			name: "no status rows clear the status",
+			objects: []client.Object{&policiesv1.Policy{
+				ObjectMeta: objectMeta("spec"),
+				Status: policiesv1.PolicyStatus{
+					ComplianceState: policiesv1.Compliant,
+					Status:          []*policiesv1.CompliancePerClusterStatus{{ClusterName: "cluster1"}},
+				},
+			}},
+			wantApplied: true,
+			wantPatched: true,
+			check: func(t *testing.T, k8sClient client.Client) {
+				t.Helper()
+
+				deployedPolicy := &policiesv1.Policy{}
+				getObject(t, k8sClient, "spec", deployedPolicy)
+
+				if deployedPolicy.Status.ComplianceState != "" || len(deployedPolicy.Status.Status) != 0 {
+					t.Errorf("status was not cleared: %+v", deployedPolicy.Status)
+				}
+			},
+		},
+		{
+			name: "unchanged status is not patched",
+			objects: []client.Object{&policiesv1.Policy{
+				ObjectMeta: objectMeta("spec"),
+				Status: policiesv1.PolicyStatus{
+					ComplianceState: policiesv1.Compliant,
+					Status: []*policiesv1.CompliancePerClusterStatus{{
+						ComplianceState:  policiesv1.Compliant,
+						ClusterName:      "cluster1",
+						ClusterNamespace: "cluster1",
+					}},
+				},
+			}},
+			statusRows: []testStatusRow{
+				{leafHubName: "hub1", clusterName: "cluster1", compliance: dbEnumCompliant},
+			},
+			wantStatuses: 1,
+			wantApplied:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			dbSyncer, _ := newPolicyDBSyncer(nil)
+			runDBSyncerTestCase(t, dbSyncer, policiesSpecTableName, testCase)
+		})
+	}
+}
+
+func TestPlacementRuleDBSyncer(t *testing.T) {
+	leafHubPlacementRule := func(clusterNames ...string) *placementrulesv1.PlacementRule {
+		placementRule := &placementrulesv1.PlacementRule{ObjectMeta: objectMeta("spec")}
+
+		for _, clusterName := range clusterNames {
+			placementRule.Status.Decisions = append(placementRule.Status.Decisions,
+				placementrulesv1.PlacementDecision{ClusterName: clusterName, ClusterNamespace: clusterName})
+		}
+
+		return placementRule
+	}
+
+	testCases := []*dbSyncerTestCase{
+		{
+			name:    "decisions of all the leaf hubs",
+			objects: []client.Object{&placementrulesv1.PlacementRule{ObjectMeta: objectMeta("spec")}},
+			statusRows: []testStatusRow{
+				{leafHubName: "hub1", object: leafHubPlacementRule("cluster1", "cluster2")},
+				{leafHubName: "hub2", object: leafHubPlacementRule("cluster3")},
+			},
+			wantStatuses: 2,
+			wantApplied:  true,
+			wantPatched:  true,
+			check: func(t *testing.T, k8sClient client.Client) {
+				t.Helper()
+
+				placementRule := &placementrulesv1.PlacementRule{}
+				getObject(t, k8sClient, "spec", placementRule)
+
+				if len(placementRule.Status.Decisions) != 3 || placementRule.Status.Decisions[2].ClusterName != "cluster3" {
+					t.Errorf("unexpected decisions: %+v", placementRule.Status.Decisions)
+				}
+			},
+		},
+		{
+			name:    "no status rows",
+			objects: []client.Object{&placementrulesv1.PlacementRule{ObjectMeta: objectMeta("spec")}},
+		},
+		{
+			name:    "only stale leaf hubs reported",
+			objects: []client.Object{leafHubPlacementRule("cluster1")},
+			statusRows: []testStatusRow{
+				{leafHubName: "hub2", object: leafHubPlacementRule("cluster1")},
+			},
+			wantStatuses:       1,
+			wantApplied:        true,
+			wantPatched:        true,
+			staleLeafHubs:      staleLeafHubs("hub2"),
+			staleLeafHubPolicy: StaleLeafHubPolicyDrop,
+			wantStaleLeafHubs:  "hub2",
+			check: func(t *testing.T, k8sClient client.Client) {
+				t.Helper()
+
+				placementRule := &placementrulesv1.PlacementRule{}
+				getObject(t, k8sClient, "spec", placementRule)
+
+				if len(placementRule.Status.Decisions) != 0 {
+					t.Errorf("decisions of stale leaf hubs were not cleared: %+v", placementRule.Status.Decisions)
+				}
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			dbSyncer, _ := newPlacementRuleDBSyncer(nil)
+			runDBSyncerTestCase(t, dbSyncer, placementRulesSpecTableName, testCase)
+		})
+	}
+}
+
+func TestPlacementDBSyncer(t *testing.T) {
+	leafHubPlacement := func(numberOfSelectedClusters int32) *clustersv1beta1.Placement {
+		return &clustersv1beta1.Placement{
+			ObjectMeta: objectMeta("spec"),
+			Status:     clustersv1beta1.PlacementStatus{NumberOfSelectedClusters: numberOfSelectedClusters},
+		}
+	}
+
+	testCases := []*dbSyncerTestCase{
+		{
+			name:    "selected clusters of all the leaf hubs",
+			objects: []client.Object{&clustersv1beta1.Placement{ObjectMeta: objectMeta("spec")}},
+			statusRows: []testStatusRow{
+				{leafHubName: "hub1", object: leafHubPlacement(2)},
+				{leafHubName: "hub2", object: leafHubPlacement(3)},
+			},
+			wantStatuses: 2,
+			wantApplied:  true,
+			wantPatched:  true,
+			check: func(t *testing.T, k8sClient client.Client) {
+				t.Helper()
+
+				placement := &clustersv1beta1.Placement{}
+				getObject(t, k8sClient, "spec", placement)
+
+				if placement.Status.NumberOfSelectedClusters != 5 {
+					t.Errorf("number of selected clusters is %d, want 5", placement.Status.NumberOfSelectedClusters)
+				}
+			},
+		},
+		{
+			name:    "no status rows",
+			objects: []client.Object{&clustersv1beta1.Placement{ObjectMeta: objectMeta("spec")}},
+		},
+		{
+			name:    "only stale leaf hubs reported",
+			objects: []client.Object{leafHubPlacement(3)},
+			statusRows: []testStatusRow{
+				{leafHubName: "hub2", object: leafHubPlacement(3)},
+			},
+			wantStatuses:       1,
+			wantApplied:        true,
+			wantPatched:        true,
+			staleLeafHubs:      staleLeafHubs("hub2"),
+			staleLeafHubPolicy: StaleLeafHubPolicyDrop,
+			wantStaleLeafHubs:  "hub2",
+			check: func(t *testing.T, k8sClient client.Client) {
+				t.Helper()
+
+				placement := &clustersv1beta1.Placement{}
+				getObject(t, k8sClient, "spec", placement)
+
+				if placement.Status.NumberOfSelectedClusters != 0 {
+					t.Errorf("number of selected clusters is %d, want 0", placement.Status.NumberOfSelectedClusters)
+				}
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			dbSyncer, _ := newPlacementDBSyncer(nil)
+			runDBSyncerTestCase(t, dbSyncer, placementsSpecTableName, testCase)
+		})
+	}
+}
+
+func TestPlacementDecisionDBSyncer(t *testing.T) {
+	leafHubPlacementDecision := func(clusterNames ...string) *clustersv1beta1.PlacementDecision {
+		placementDecision := &clustersv1beta1.PlacementDecision{ObjectMeta: objectMeta("spec-decision-1")}
+		placementDecision.Labels = map[string]string{clustersv1beta1.PlacementLabel: "spec"}
+
+		for _, clusterName := range clusterNames {
+			placementDecision.Status.Decisions = append(placementDecision.Status.Decisions,
+				clustersv1beta1.ClusterDecision{ClusterName: clusterName})
+		}
+
+		return placementDecision
+	}
+
+	checkDecisions := func(t *testing.T, k8sClient client.Client) {
+		t.Helper()
+
+		placementDecision := &clustersv1beta1.PlacementDecision{}
+		getObject(t, k8sClient, "spec-decision-1", placementDecision)
+
+		if clusterNames := placementDecisionClusterNames(placementDecision); len(clusterNames) != 2 ||
+			clusterNames[0] != "cluster1" || clusterNames[1] != "cluster2" {
+			t.Errorf("unexpected decisions: %v", clusterNames)
+		}
+
+		if controllerUID(placementDecision) != testUID {
+			t.Errorf("placement-decision is not owned by its placement: %+v", placementDecision.OwnerReferences)
+		}
+	}
+
+	testCases := []*dbSyncerTestCase{
+		{
+			name: "created with the decisions of all the leaf hubs",
+			statusRows: []testStatusRow{
+				{leafHubName: "hub1", object: leafHubPlacementDecision("cluster1")},
+				{leafHubName: "hub2", object: leafHubPlacementDecision("cluster2")},
+			},
+			wantStatuses: 2,
+			wantApplied:  true,
+			wantPatched:  true,
+			check: func(t *testing.T, k8sClient client.Client) {
+				t.Helper()
+
+				checkDecisions(t, k8sClient)
+
+				placementDecision := &clustersv1beta1.PlacementDecision{}
+				getObject(t, k8sClient, "spec-decision-1", placementDecision)
+
+				if placementDecision.Labels[statusSyncLabelKey] != statusSyncLabelValue {
+					t.Errorf("created placement-decision is not labeled: %v", placementDecision.Labels)
+				}
+			},
+		},
+		{
+			name:    "deployed decisions are patched",
+			objects: []client.Object{leafHubPlacementDecision("cluster1")},
+			statusRows: []testStatusRow{
+				{leafHubName: "hub1", object: leafHubPlacementDecision("cluster1", "cluster2")},
+			},
+			wantStatuses: 1,
+			wantApplied:  true,
+			wantPatched:  true,
+			check: func(t *testing.T, k8sClient client.Client) {
+				t.Helper()
+
+				placementDecision := &clustersv1beta1.PlacementDecision{}
+				getObject(t, k8sClient, "spec-decision-1", placementDecision)
+
+				if len(placementDecision.Status.Decisions) != 2 {
+					t.Errorf("unexpected decisions: %+v", placementDecision.Status.Decisions)
+				}
+			},
+		},
+		{
+			name: "placement-decisions without placement label are ignored",
+			statusRows: []testStatusRow{
+				{leafHubName: "hub1", object: &clustersv1beta1.PlacementDecision{ObjectMeta: objectMeta("spec")}},
+			},
+		},
+		{
+			name: "only stale leaf hubs reported",
+			objects: []client.Object{
+				&clustersv1beta1.Placement{ObjectMeta: objectMeta("spec")},
+				leafHubPlacementDecision("cluster1"),
+			},
+			statusRows: []testStatusRow{
+				{leafHubName: "hub2", object: leafHubPlacementDecision("cluster1")},
+			},
+			wantStatuses:       1,
+			wantApplied:        true,
+			wantPatched:        true,
+			staleLeafHubs:      staleLeafHubs("hub2"),
+			staleLeafHubPolicy: StaleLeafHubPolicyDrop,
+			wantStaleLeafHubs:  "hub2",
+			check: func(t *testing.T, k8sClient client.Client) {
+				t.Helper()
+
+				placementDecision := &clustersv1beta1.PlacementDecision{}
+				getObject(t, k8sClient, "spec-decision-1", placementDecision)
+
+				if len(placementDecision.Status.Decisions) != 0 {
+					t.Errorf("decisions of stale leaf hubs were not cleared: %+v", placementDecision.Status.Decisions)
+				}
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			dbSyncer, _ := newPlacementDecisionDBSyncer(nil)
+			runDBSyncerTestCase(t, dbSyncer, placementsSpecTableName, testCase)
+		})
+	}
+}
+
+// statusSubresourceClient drops the status of the created placement-decisions, like the API server for the CRDs with
+// a status subresource.
+type statusSubresourceClient struct {
+	client.Client
+}
+
+func (k8sClient *statusSubresourceClient) Create(ctx context.Context, object client.Object,
+	opts ...client.CreateOption) error {
+	if placementDecision, ok := object.(*clustersv1beta1.PlacementDecision); ok {
+		placementDecision.Status = clustersv1beta1.PlacementDecisionStatus{}
+	}
+
+	return k8sClient.Client.Create(ctx, object, opts...)
+}
+
+func TestCreatedPlacementDecisionStatusIsPatched(t *testing.T) {
+	k8sClient := &statusSubresourceClient{Client: fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()}
+
+	placementDecision := &clustersv1beta1.PlacementDecision{ObjectMeta: objectMeta("spec-decision-1")}
+	placementDecision.Status.Decisions = []clustersv1beta1.ClusterDecision{{ClusterName: "cluster1"}}
+
+	patched, err := updatePlacementDecision(context.Background(), k8sClient, placementDecision)
+	if err != nil || !patched {
+		t.Fatalf("failed to create placement-decision: patched %t, error %v", patched, err)
+	}
+
+	deployedPlacementDecision := &clustersv1beta1.PlacementDecision{}
+	getObject(t, k8sClient, "spec-decision-1", deployedPlacementDecision)
+
+	if clusterNames := placementDecisionClusterNames(deployedPlacementDecision); len(clusterNames) != 1 ||
+		clusterNames[0] != "cluster1" {
+		t.Errorf("status of created placement-decision was not patched: %v", clusterNames)
+	}
+}
+
+func TestSubscriptionStatusDBSyncer(t *testing.T) {
+	leafHubSubscriptionStatus := func(packageNames ...string) *appsv1alpha1.SubscriptionStatus {
+		subscriptionStatus := &appsv1alpha1.SubscriptionStatus{ObjectMeta: objectMeta("spec")}
+
+		for _, packageName := range packageNames {
+			subscriptionStatus.Statuses.SubscriptionStatus = append(subscriptionStatus.Statuses.SubscriptionStatus,
+				appsv1alpha1.SubscriptionUnitStatus{Name: packageName, Kind: "Deployment", Phase: "Deployed"})
+		}
+
+		return subscriptionStatus
+	}
+
+	testCases := []*dbSyncerTestCase{
+		{
+			name: "created with the packages of all the leaf hubs",
+			statusRows: []testStatusRow{
+				{leafHubName: "hub1", object: leafHubSubscriptionStatus("package1")},
+				{leafHubName: "hub2", object: leafHubSubscriptionStatus("package2")},
+			},
+			wantStatuses: 2,
+			wantApplied:  true,
+			wantPatched:  true,
+			check: func(t *testing.T, k8sClient client.Client) {
+				t.Helper()
+
+				subscriptionStatus := &appsv1alpha1.SubscriptionStatus{}
+				getObject(t, k8sClient, "spec", subscriptionStatus)
+
+				packages := subscriptionStatus.Statuses.SubscriptionStatus
+				if len(packages) != 2 || packages[0].Name != "package1" || packages[1].Name != "package2" {
+					t.Errorf("unexpected packages: %+v", packages)
+				}
+
+				if controllerUID(subscriptionStatus) != testUID {
+					t.Errorf("subscription-status is not owned by its subscription: %+v",
+						subscriptionStatus.OwnerReferences)
+				}
+			},
+		},
+		{
+			name: "no status rows",
+		},
+		{
+			name:    "only stale leaf hubs reported",
+			objects: []client.Object{leafHubSubscriptionStatus("package1")},
+			statusRows: []testStatusRow{
+				{leafHubName: "hub2", object: leafHubSubscriptionStatus("package1")},
+			},
+			wantStatuses:       1,
+			wantApplied:        true,
+			wantPatched:        true,
+			staleLeafHubs:      staleLeafHubs("hub2"),
+			staleLeafHubPolicy: StaleLeafHubPolicyDrop,
+			check: func(t *testing.T, k8sClient client.Client) {
+				t.Helper()
+
+				subscriptionStatus := &appsv1alpha1.SubscriptionStatus{}
+				getObject(t, k8sClient, "spec", subscriptionStatus)
+
+				if packages := subscriptionStatus.Statuses.SubscriptionStatus; len(packages) != 0 {
+					t.Errorf("packages of stale leaf hubs were not cleared: %+v", packages)
+				}
+			},
+		},
+		{
+			name: "packages of stale leaf hubs are marked",
+			statusRows: []testStatusRow{
+				{leafHubName: "hub1", object: leafHubSubscriptionStatus("package1")},
+				{leafHubName: "hub2", object: leafHubSubscriptionStatus("package2")},
+			},
+			wantStatuses:       2,
+			wantApplied:        true,
+			wantPatched:        true,
+			staleLeafHubs:      staleLeafHubs("hub2"),
+			staleLeafHubPolicy: StaleLeafHubPolicyMark,
+			check: func(t *testing.T, k8sClient client.Client) {
+				t.Helper()
+
+				subscriptionStatus := &appsv1alpha1.SubscriptionStatus{}
+				getObject(t, k8sClient, "spec", subscriptionStatus)
+
+				packages := subscriptionStatus.Statuses.SubscriptionStatus
+				if len(packages) != 2 || packages[0].Phase != "Deployed" ||
+					packages[1].Phase != appsv1alpha1.PackageUnknown || packages[1].Message != staleLeafHubReason {
+					t.Errorf("unexpected packages: %+v", packages)
+				}
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			dbSyncer, _ := newSubscriptionStatusDBSyncer(nil)
+			runDBSyncerTestCase(t, dbSyncer, subscriptionsSpecTableName, testCase)
+		})
+	}
+}
+
+func TestSubscriptionReportDBSyncer(t *testing.T) {
+	leafHubSubscriptionReport := func(clusterNames ...string) *appsv1alpha1.SubscriptionReport {
+		subscriptionReport := &appsv1alpha1.SubscriptionReport{ObjectMeta: objectMeta("spec")}
+		subscriptionReport.Summary.Deployed = "0"
+		subscriptionReport.Summary.Clusters = "0"
+
+		for _, clusterName := range clusterNames {
+			subscriptionReport.Results = append(subscriptionReport.Results,
+				&appsv1alpha1.SubscriptionReportResult{Source: clusterName, Result: subscriptionResultDeployed})
+			subscriptionReport.Summary.Deployed = add(subscriptionReport.Summary.Deployed, "1")
+			subscriptionReport.Summary.Clusters = add(subscriptionReport.Summary.Clusters, "1")
+		}
+
+		return subscriptionReport
+	}
+
+	checkSummary := func(deployed string, clusters string, results int) func(*testing.T, client.Client) {
+		return func(t *testing.T, k8sClient client.Client) {
+			t.Helper()
+
+			subscriptionReport := &appsv1alpha1.SubscriptionReport{}
+			getObject(t, k8sClient, "spec", subscriptionReport)
+
+			if subscriptionReport.Summary.Deployed != deployed || subscriptionReport.Summary.Clusters != clusters {
+				t.Errorf("summary is %+v, want %s deployed of %s clusters", subscriptionReport.Summary, deployed,
+					clusters)
+			}
+
+			if len(subscriptionReport.Results) != results {
+				t.Errorf("got %d results, want %d", len(subscriptionReport.Results), results)
+			}
+		}
+	}
+
+	testCases := []*dbSyncerTestCase{
+		{
+			name: "created with the summed summaries",
+			statusRows: []testStatusRow{
+				{leafHubName: "hub1", object: leafHubSubscriptionReport("cluster1", "cluster2")},
+				{leafHubName: "hub2", object: leafHubSubscriptionReport("cluster3")},
+			},
+			wantStatuses: 2,
+			wantApplied:  true,
+			wantPatched:  true,
+			check:        checkSummary("3", "3", 3),
+		},
+		{
+			name: "duplicate clusters are counted once",
+			statusRows: []testStatusRow{
+				{leafHubName: "hub1", object: leafHubSubscriptionReport("cluster1", "cluster2")},
+				{leafHubName: "hub2", object: leafHubSubscriptionReport("cluster2")},
+			},
+			wantStatuses: 2,
+			wantApplied:  true,
+			wantPatched:  true,
+			check:        checkSummary("2", "2", 3),
+		},
+		{
+			name:    "unchanged report is not patched",
+			objects: []client.Object{leafHubSubscriptionReport("cluster1")},
+			statusRows: []testStatusRow{
+				{leafHubName: "hub1", object: leafHubSubscriptionReport("cluster1")},
+			},
+			wantStatuses: 1,
+			wantApplied:  true,
+			check:        checkSummary("1", "1", 1),
+		},
+		{
+			name:    "only stale leaf hubs reported",
+			objects: []client.Object{leafHubSubscriptionReport("cluster1")},
+			statusRows: []testStatusRow{
+				{leafHubName: "hub2", object: leafHubSubscriptionReport("cluster1")},
+			},
+			wantStatuses:       1,
+			wantApplied:        true,
+			wantPatched:        true,
+			staleLeafHubs:      staleLeafHubs("hub2"),
+			staleLeafHubPolicy: StaleLeafHubPolicyDrop,
+			check:              checkSummary("0", "0", 0),
+		},
+		{
+			name: "results of stale leaf hubs are marked",
+			statusRows: []testStatusRow{
+				{leafHubName: "hub1", object: leafHubSubscriptionReport("cluster1")},
+				{leafHubName: "hub2", object: leafHubSubscriptionReport("cluster2")},
+			},
+			wantStatuses:       2,
+			wantApplied:        true,
+			wantPatched:        true,
+			staleLeafHubs:      staleLeafHubs("hub2"),
+			staleLeafHubPolicy: StaleLeafHubPolicyMark,
+			check: func(t *testing.T, k8sClient client.Client) {
+				t.Helper()
+
+				checkSummary("1", "2", 2)(t, k8sClient)
+
+				subscriptionReport := &appsv1alpha1.SubscriptionReport{}
+				getObject(t, k8sClient, "spec", subscriptionReport)
+
+				if result := subscriptionReport.Results[1]; result.Source != "cluster2" || result.Result != "" {
+					t.Errorf("result of stale leaf hub is not unknown: %+v", result)
+				}
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			dbSyncer, _ := newSubscriptionReportDBSyncer(nil)
+			runDBSyncerTestCase(t, dbSyncer, subscriptionsSpecTableName, testCase)
+		})
+	}
+}
+
+func TestFetchStatusPayloadsSkipsInvalidPayloads(t *testing.T) {
+	store := NewMemoryStore()
+	store.AddStatusRow(placementsStatusTableName, &MemoryStatusRow{
+		LeafHubName: "hub1",
+		Payload:     marshalPayload(t, &clustersv1beta1.Placement{ObjectMeta: objectMeta("spec")}),
+	})
+	store.AddStatusRow(placementsStatusTableName, &MemoryStatusRow{
+		LeafHubName: "hub2",
+		Payload:     json.RawMessage(`{"metadata": {"name": "spec", "namespace": "default"}, "status": []}`),
+	})
+
+	objects := []*SpecObject{{Key: namespacedNameKey(testNamespace, "spec"), Name: "spec", Namespace: testNamespace}}
+
+	leafHubStatuses, err := FetchStatusPayloads(context.Background(), store, placementsStatusTableName,
+		StatusRowNamespacedNameKey, objects, func() interface{} { return &clustersv1beta1.Placement{} })
+	if err != nil {
+		t.Fatalf("failed to fetch status payloads: %v", err)
+	}
+
+	statuses := leafHubStatuses[objects[0].Key]
+	if len(statuses) != 2 {
+		t.Fatalf("got %d statuses, want 2", len(statuses))
+	}
+
+	if statuses[0].Err != nil || statuses[0].Status == nil {
+		t.Errorf("valid payload of hub1 was not decoded: %+v", statuses[0])
+	}
+
+	if statuses[1].Err == nil || statuses[1].Status != nil {
+		t.Errorf("invalid payload of hub2 was decoded: %+v", statuses[1])
+	}
+}
+
+func TestDBSyncersMetricsRegisterers(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		workerPool, err := newWorkerPool(1, 0)
+		if err != nil {
+			t.Fatalf("failed to create worker pool: %v", err)
+		}
+
+		if _, err := newDBSyncersMetrics(prometheus.NewRegistry(), workerPool); err != nil {
+			t.Fatalf("failed to register the metrics of DB syncers %d: %v", i, err)
+		}
+	}
+}
+
+func TestListSyncedObjectsSkipsStatusesOfOtherWriters(t *testing.T) {
+	otherPolicy := &policiesv1.Policy{ObjectMeta: objectMeta("other")}
+	otherPolicy.Status.ComplianceState = policiesv1.Compliant
+	otherPlacementRule := &placementrulesv1.PlacementRule{ObjectMeta: objectMeta("other")}
+	otherPlacementRule.Status.Decisions = []placementrulesv1.PlacementDecision{{ClusterName: "cluster1"}}
+	otherPlacement := &clustersv1beta1.Placement{ObjectMeta: objectMeta("other")}
+	otherPlacement.Status.NumberOfSelectedClusters = 1
+
+	testCases := []struct {
+		name             string
+		dbSyncer         GarbageCollectedDBSyncer
+		objects          []client.Object
+		aggregatedStatus interface{}
+		wantKey          string
+	}{
+		{
+			name:     "policies",
+			dbSyncer: &policyDBSyncer{},
+			objects: []client.Object{
+				&policiesv1.Policy{ObjectMeta: metav1.ObjectMeta{Name: "spec", Namespace: testNamespace, UID: testUID}},
+				otherPolicy,
+			},
+			aggregatedStatus: &policyComplianceStatus{
+				compliancePerClusterStatuses: []*policiesv1.CompliancePerClusterStatus{
+					{ComplianceState: policiesv1.Compliant, ClusterName: "cluster1", ClusterNamespace: "cluster1"},
+				},
+			},
+			wantKey: testUID,
+		},
+		{
+			name:     "placementrules",
+			dbSyncer: &placementRuleDBSyncer{},
+			objects:  []client.Object{&placementrulesv1.PlacementRule{ObjectMeta: objectMeta("spec")}, otherPlacementRule},
+			aggregatedStatus: &placementrulesv1.PlacementRuleStatus{
+				Decisions: []placementrulesv1.PlacementDecision{{ClusterName: "cluster1", ClusterNamespace: "cluster1"}},
+			},
+			wantKey: namespacedNameKey(testNamespace, "spec"),
+		},
+		{
+			name:             "placements",
+			dbSyncer:         &placementDBSyncer{},
+			objects:          []client.Object{&clustersv1beta1.Placement{ObjectMeta: objectMeta("spec")}, otherPlacement},
+			aggregatedStatus: &clustersv1beta1.PlacementStatus{NumberOfSelectedClusters: 1},
+			wantKey:          namespacedNameKey(testNamespace, "spec"),
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx := context.Background()
+			k8sClient := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(testCase.objects...).Build()
+			object := &SpecObject{Key: testCase.wantKey, UID: testUID, Name: "spec", Namespace: testNamespace}
+
+			if _, err := testCase.dbSyncer.Apply(ctx, k8sClient, object, testCase.aggregatedStatus); err != nil {
+				t.Fatalf("failed to apply status: %v", err)
+			}
+
+			syncedObjects, err := testCase.dbSyncer.ListSyncedObjects(ctx, k8sClient)
+			if err != nil {
+				t.Fatalf("failed to list synced objects: %v", err)
+			}
+
+			if len(syncedObjects) != 1 || syncedObjects[0].Key != testCase.wantKey {
+				t.Errorf("unexpected synced objects: %+v", syncedObjects)
+			}
+		})
+	}
+}
+
+func TestDBListenerDispatchesToEverySubscription(t *testing.T) {
+	listener := newDBListener(logr.Discard(), nil)
+	channel := statusNotificationChannel(complianceStatusTableName)
+	subscriptions := []*dbNotificationSubscription{listener.subscribe(channel), listener.subscribe(channel)}
+	otherSubscription := listener.subscribe(statusNotificationChannel(placementsStatusTableName))
+
+	listener.dispatch(channel, testUID)
+
+	for i, subscription := range subscriptions {
+		select {
+		case key := <-subscription.keysChannel():
+			if key != testUID {
+				t.Errorf("subscription %d received key %s, want %s", i, key, testUID)
+			}
+		default:
+			t.Errorf("subscription %d did not receive the key", i)
+		}
+	}
+
+	select {
+	case key := <-otherSubscription.keysChannel():
+		t.Errorf("subscription of another channel received key %s", key)
+	default:
+	}
+}
+
+func TestGarbageCollectionErrorsAreCountedApart(t *testing.T) {
+	workerPool, err := newWorkerPool(1, 0)
+	if err != nil {
+		t.Fatalf("failed to create worker pool: %v", err)
+	}
+
+	dbSyncersMetrics, err := newDBSyncersMetrics(prometheus.NewRegistry(), workerPool)
+	if err != nil {
+		t.Fatalf("failed to create metrics: %v", err)
+	}
+
+	metrics := dbSyncersMetrics.forSyncer(placementsDBSyncerName)
+	gc := &garbageCollector{
+		log:       logr.Discard(),
+		specStore: NewMemoryStore(),
+		// the placements are not in the scheme, so that listing them fails
+		k8sReader: fake.NewClientBuilder().WithScheme(runtime.NewScheme()).Build(),
+		interval:  time.Second,
+	}
+
+	gc.collect(context.Background(), &garbageCollectedSyncer{
+		name:     placementsDBSyncerName,
+		dbSyncer: &placementDBSyncer{},
+		metrics:  metrics,
+	})
+
+	if got := testutil.ToFloat64(metrics.gcErrors.WithLabelValues(operationList)); got != 1 {
+		t.Errorf("counted %v garbage collection list errors, want 1", got)
+	}
+
+	if got := metrics.errorCount(); got != 0 {
+		t.Errorf("counted %d sync errors, want 0", got)
+	}
+}
@@ -4,19 +4,23 @@
 package dbsyncers
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
-	"github.com/jackc/pgx/v4/pgxpool"
 	policiesv1 "github.com/open-cluster-management/governance-policy-propagator/api/v1"
+	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/apimachinery/pkg/runtime"
 	clustersv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
 	placementrulesv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/placementrule/v1"
 	appsv1alpha1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1alpha1"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/scheme"
 )
 
+var errInvalidConfiguration = errors.New("invalid configuration")
+
 // AddToScheme adds all the resources to be processed to the Scheme.
 func AddToScheme(runtimeScheme *runtime.Scheme) error {
 	schemeBuilders := []*scheme.Builder{
@@ -44,22 +48,283 @@ func AddToScheme(runtimeScheme *runtime.Scheme) error {
 	return nil
 }
 
-// AddDBSyncers adds all the DBSyncers to the Manager.
-func AddDBSyncers(mgr ctrl.Manager, dbConnectionPool *pgxpool.Pool, syncInterval time.Duration) error {
-	addDBSyncerFunctions := []func(ctrl.Manager, *pgxpool.Pool, time.Duration) error{
-		addPolicyDBSyncer,
-		addPlacementRuleStatusDBSyncer,
-		addPlacementStatusDBSyncer,
-		addPlacementDecisionDBSyncer,
-		addSubscriptionStatusStatusDBSyncer,
-		addSubscriptionReportDBSyncer,
+// SyncerConfig holds the configuration of a single DBSyncer, zero values fall back to the global configuration.
+type SyncerConfig struct {
+	// Disabled prevents the DBSyncer from being added to the Manager, or pauses it if Config.Reloader is set.
+	Disabled bool
+	// SyncInterval overrides Config.SyncInterval, it does not apply to the full resync of the event-driven mode.
+	SyncInterval time.Duration
+	// Concurrency overrides Config.SyncerConcurrency.
+	Concurrency int
+}
+
+// Config holds the configuration of the DBSyncers.
+type Config struct {
+	// SyncInterval is the interval of the periodic sync.
+	SyncInterval time.Duration
+	// ResyncInterval is the interval of the full resync in the event-driven and incremental modes.
+	ResyncInterval time.Duration
+	// WorkerPoolSize is the number of workers shared by all the DBSyncers.
+	WorkerPoolSize int
+	// SyncerConcurrency limits the number of objects each DBSyncer handles concurrently.
+	SyncerConcurrency int
+	// EventDriven makes the DBSyncers sync the objects announced by the status tables notifications, the periodic
+	// sync becomes a full resync every ResyncInterval.
+	EventDriven bool
+	// Incremental makes the periodic sync handle only the objects whose status rows were updated since the previous
+	// sync, with a full resync every ResyncInterval.
+	Incremental bool
+	// HealthTickMultiple is the multiple of its sync interval within which a DBSyncer must complete a tick of its
+	// periodic sync to be considered healthy.
+	HealthTickMultiple int
+	// ShutdownGracePeriod is the time the DBSyncers wait on shutdown for their in-flight handlers before abandoning
+	// them.
+	ShutdownGracePeriod time.Duration
+	// StaleLeafHubThreshold is the age of the last heartbeat of a leaf hub past which its statuses are handled by
+	// StaleLeafHubPolicy, zero ignores the heartbeats.
+	StaleLeafHubThreshold time.Duration
+	StaleLeafHubPolicy    StaleLeafHubPolicy
+	// DuplicateClustersStrategy is the way the clusters reported by more than one leaf hub are aggregated.
+	DuplicateClustersStrategy DuplicateClustersStrategy
+	// QualifyClusterNames names the clusters in the aggregated statuses by their leaf hub and name, and annotates the
+	// leaf hubs of the aggregated statuses that do not list clusters.
+	QualifyClusterNames bool
+	// ServerSideApply writes the aggregated statuses by server-side apply with the hub-of-hubs-status-sync field
+	// manager instead of merge patches, ForceOwnership takes over the fields owned by other field managers instead of
+	// failing on conflicts.
+	ServerSideApply bool
+	ForceOwnership  bool
+	// WriteRetries is the maximal number of retries of a status write that failed with a transient error, e.g. a
+	// conflict or a server error. the retries are delayed by an exponential backoff from WriteRetryInitialInterval
+	// up to WriteRetryMaxInterval.
+	WriteRetries              int
+	WriteRetryInitialInterval time.Duration
+	WriteRetryMaxInterval     time.Duration
+	// GarbageCollectionInterval is the interval of the garbage collection of the CRs whose spec objects are deleted,
+	// zero disables the garbage collection.
+	GarbageCollectionInterval time.Duration
+	// GarbageCollectionDryRun makes the garbage collection only log and count the CRs it would collect.
+	GarbageCollectionDryRun bool
+	// DryRun makes the DB syncers log the changes they would make to the CRs instead of making them, and write them
+	// as JSON lines to DryRunFile if set.
+	DryRun     bool
+	DryRunFile string
+	// Once makes every DB syncer sync all the objects once instead of periodically, and report its summary to Once.
+	// the garbage collection is disabled.
+	Once *OnceReport
+	// Reloader is set to change the sync intervals, the concurrency limits and the enabled DB syncers while they run,
+	// the disabled DB syncers are then added to the Manager but paused. it is ignored if Once is set.
+	Reloader *Reloader
+	// Syncers holds the configuration of specific DBSyncers, by their names as returned by Registry.Names.
+	Syncers map[string]SyncerConfig
+	// MetricsRegisterer registers the metrics of the DB syncers, the controller-runtime metrics.Registry if nil. each
+	// call of AddDBSyncers needs its own registerer, e.g. a prometheus.NewRegistry() in tests.
+	MetricsRegisterer prometheus.Registerer
+}
+
+// AddDBSyncers adds all the enabled DBSyncers of the registry to the Manager, together with the worker pool they
+// share. the event-driven mode requires a PostgreSQLStore.
+func AddDBSyncers(mgr ctrl.Manager, store Store, registry *Registry, config *Config) error {
+	if config.EventDriven && config.Incremental {
+		return fmt.Errorf("%w: event-driven and incremental modes are mutually exclusive", errInvalidConfiguration)
+	}
+
+	if err := validateAggregationConfig(config); err != nil {
+		return err
+	}
+
+	for syncerName := range config.Syncers {
+		if _, found := registry.factories[syncerName]; !found {
+			return fmt.Errorf("%w: unknown DB syncer %s", errInvalidConfiguration, syncerName)
+		}
+	}
+
+	// the workers are kept until the DBSyncers abandon their in-flight handlers and these return.
+	workerPool, err := newWorkerPool(config.WorkerPoolSize, config.ShutdownGracePeriod+abandonedHandlersTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to create worker pool: %w", err)
+	}
+
+	if err := mgr.Add(workerPool); err != nil {
+		return fmt.Errorf("failed to add worker pool to the manager: %w", err)
+	}
+
+	var listener *dbListener // nil listener means polling mode
+
+	if config.EventDriven {
+		postgreSQLStore, ok := store.(*PostgreSQLStore)
+		if !ok {
+			return fmt.Errorf("%w: the event-driven mode requires PostgreSQL notifications", errInvalidConfiguration)
+		}
+
+		listener = newDBListener(ctrl.Log.WithName("db-listener"), postgreSQLStore.databaseConnectionPool)
+
+		if err := mgr.Add(listener); err != nil {
+			return fmt.Errorf("failed to add DB listener to the manager: %w", err)
+		}
+	}
+
+	metricsRegisterer := config.MetricsRegisterer
+	if metricsRegisterer == nil {
+		metricsRegisterer = metrics.Registry
+	}
+
+	dbSyncersMetrics, err := newDBSyncersMetrics(metricsRegisterer, workerPool)
+	if err != nil {
+		return fmt.Errorf("failed to create DB syncers metrics: %w", err)
+	}
+
+	health := newDBSyncersHealth(config.HealthTickMultiple)
+
+	var dryRunReporter *dryRunReporter // nil reporter means the changes are made
+
+	if config.DryRun {
+		if dryRunReporter, err = newDryRunReporter(ctrl.Log.WithName("dry-run"), config.DryRunFile); err != nil {
+			return fmt.Errorf("failed to create dry-run reporter: %w", err)
+		}
+
+		if err := mgr.Add(dryRunReporter); err != nil {
+			return fmt.Errorf("failed to add dry-run reporter to the manager: %w", err)
+		}
+	}
+
+	gc := &garbageCollector{
+		log:       ctrl.Log.WithName("garbage-collector"),
+		specStore: store,
+		k8sClient: mgr.GetClient(),
+		k8sReader: mgr.GetAPIReader(),
+		interval:  config.GarbageCollectionInterval,
+		dryRun:    config.GarbageCollectionDryRun || config.DryRun,
+	}
+
+	reloader := config.Reloader
+	if config.Once != nil {
+		reloader = nil
+	}
+
+	syncers := 0
+
+	for syncerName, factory := range registry.factories {
+		syncerConfig := config.Syncers[syncerName]
+		if syncerConfig.Disabled && reloader == nil {
+			ctrl.Log.WithName(syncerName).Info("DB syncer is disabled")
+			continue
+		}
+
+		workers, err := workerPool.newSyncerWorkers(syncerConcurrency(config, &syncerConfig))
+		if err != nil {
+			return fmt.Errorf("failed to create syncer workers: %w", err)
+		}
+
+		dbSyncer, err := factory(mgr)
+		if err != nil {
+			return fmt.Errorf("failed to create DB Syncer %s: %w", syncerName, err)
+		}
+
+		dbSyncerConfig := newDBSyncerConfig(syncerName, config, &syncerConfig, dbSyncersMetrics,
+			newSyncerEvents(mgr.GetEventRecorderFor(syncerName)), workers, listener, health)
+		dbSyncerConfig.dryRunReporter = dryRunReporter
+		dbSyncerConfig.onceReport = config.Once
+		dbSyncerConfig.paused = syncerConfig.Disabled
+
+		if syncerConfig.Disabled {
+			dbSyncerConfig.log.Info("DB syncer is disabled, paused until enabled by a configuration reload")
+		}
+
+		genericSyncer := newGenericDBSyncer(dbSyncerConfig, store, mgr.GetClient(), mgr.GetAPIReader(), dbSyncer)
+
+		if err := mgr.Add(genericSyncer); err != nil {
+			return fmt.Errorf("failed to add DB Syncer %s: %w", syncerName, err)
+		}
+
+		syncers++
+
+		if reloader != nil {
+			reloader.register(syncerName, genericSyncer)
+		}
+
+		if garbageCollectedDBSyncer, ok := dbSyncer.(GarbageCollectedDBSyncer); ok {
+			gc.syncers = append(gc.syncers, &garbageCollectedSyncer{
+				name:          syncerName,
+				dbSyncer:      garbageCollectedDBSyncer,
+				metrics:       dbSyncerConfig.metrics,
+				genericSyncer: genericSyncer,
+			})
+		}
 	}
 
-	for _, addDBSyncerFunction := range addDBSyncerFunctions {
-		if err := addDBSyncerFunction(mgr, dbConnectionPool, syncInterval); err != nil {
-			return fmt.Errorf("failed to add DB Syncer: %w", err)
+	if reloader != nil {
+		reloader.start(ctrl.Log.WithName("reloader"), config)
+	}
+
+	if config.Once != nil {
+		config.Once.expect(syncers)
+	}
+
+	if config.GarbageCollectionInterval > 0 && config.Once == nil {
+		if err := mgr.Add(gc); err != nil {
+			return fmt.Errorf("failed to add garbage collector to the manager: %w", err)
 		}
 	}
 
+	if err := addHealthChecks(mgr, store, health); err != nil {
+		return fmt.Errorf("failed to add health checks: %w", err)
+	}
+
 	return nil
 }
+
+func newDBSyncerConfig(syncerName string, config *Config, syncerConfig *SyncerConfig,
+	dbSyncersMetrics *dbSyncersMetrics, syncerEvents *syncerEvents, workers *syncerWorkers, listener *dbListener,
+	health *dbSyncersHealth) *dbSyncerConfig {
+	dbSyncerConfig := &dbSyncerConfig{
+		name:         syncerName,
+		log:          ctrl.Log.WithName(syncerName),
+		metrics:      dbSyncersMetrics.forSyncer(syncerName),
+		events:       syncerEvents,
+		syncInterval: syncerSyncInterval(config, syncerConfig),
+		workers:      workers,
+		// shutdownGracePeriod bounds the wait for in-flight handlers on shutdown.
+		shutdownGracePeriod:       config.ShutdownGracePeriod,
+		listener:                  listener,
+		health:                    health,
+		staleLeafHubThreshold:     config.StaleLeafHubThreshold,
+		staleLeafHubPolicy:        config.StaleLeafHubPolicy,
+		duplicateClustersStrategy: config.DuplicateClustersStrategy,
+		qualifyClusterNames:       config.QualifyClusterNames,
+		serverSideApply:           config.ServerSideApply,
+		forceOwnership:            config.ForceOwnership,
+		retryPolicy: &retryPolicy{
+			retries:         config.WriteRetries,
+			initialInterval: config.WriteRetryInitialInterval,
+			maxInterval:     config.WriteRetryMaxInterval,
+		},
+	}
+
+	if config.Incremental {
+		dbSyncerConfig.fullSyncInterval = config.ResyncInterval
+	}
+
+	return dbSyncerConfig
+}
+
+// syncerSyncInterval returns the interval of the periodic sync of a DBSyncer.
+func syncerSyncInterval(config *Config, syncerConfig *SyncerConfig) time.Duration {
+	switch {
+	case config.EventDriven: // the periodic sync is only a safety net for lost notifications
+		return config.ResyncInterval
+	case syncerConfig.SyncInterval > 0:
+		return syncerConfig.SyncInterval
+	default:
+		return config.SyncInterval
+	}
+}
+
+// syncerConcurrency returns the maximal number of objects a DBSyncer handles concurrently.
+func syncerConcurrency(config *Config, syncerConfig *SyncerConfig) int {
+	if syncerConfig.Concurrency > 0 {
+		return syncerConfig.Concurrency
+	}
+
+	return config.SyncerConcurrency
+}
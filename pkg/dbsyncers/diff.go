@@ -0,0 +1,231 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var errUnknownDiffKind = errors.New("no DB syncer writes the kind")
+
+// DiffFilter selects the spec objects to diff, empty fields select all.
+type DiffFilter struct {
+	// Kind is the kind of the CRs the statuses are written to, e.g. Policy or PlacementDecision, matched
+	// case-insensitively.
+	Kind      string
+	Name      string
+	Namespace string
+}
+
+func (filter *DiffFilter) matches(kind string, object *SpecObject) bool {
+	return (filter.Kind == "" || strings.EqualFold(filter.Kind, kind)) &&
+		(filter.Name == "" || filter.Name == object.Name) &&
+		(filter.Namespace == "" || filter.Namespace == object.Namespace)
+}
+
+// DiffResult holds the changes the DB syncers would make to the deployed CRs.
+type DiffResult struct {
+	// Compared is the number of spec objects whose aggregated status was compared with the deployed CRs.
+	Compared int             `json:"compared"`
+	Changes  []*StatusChange `json:"changes"`
+}
+
+// diffCollector collects the changes reported by the dry-run clients.
+type diffCollector struct {
+	lock    sync.Mutex
+	changes []*StatusChange
+}
+
+func (collector *diffCollector) report(change *StatusChange) {
+	collector.lock.Lock()
+	defer collector.lock.Unlock()
+
+	collector.changes = append(collector.changes, change)
+}
+
+// Diff aggregates the statuses of the selected spec objects as the DB syncers configured by config do and returns the
+// changes they would make to the deployed CRs, nothing is written. only the aggregation settings of config are used.
+// mgr is only used to create the DBSyncers, k8sClient should read directly from the API server.
+func Diff(ctx context.Context, mgr ctrl.Manager, k8sClient client.Client, store Store, registry *Registry,
+	config *Config, filter *DiffFilter) (*DiffResult, error) {
+	if err := validateAggregationConfig(config); err != nil {
+		return nil, err
+	}
+
+	var staleLeafHubs map[string]struct{}
+
+	if config.StaleLeafHubThreshold > 0 {
+		var err error
+
+		if staleLeafHubs, err = store.StaleLeafHubs(ctx, config.StaleLeafHubThreshold); err != nil {
+			return nil, fmt.Errorf("failed to get stale leaf hubs: %w", err)
+		}
+	}
+
+	result := &DiffResult{Changes: []*StatusChange{}}
+	collector := &diffCollector{}
+	kindFound := false
+
+	for _, syncerName := range registry.Names() {
+		dbSyncer, err := registry.factories[syncerName](mgr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create DB Syncer %s: %w", syncerName, err)
+		}
+
+		kind := targetGroupVersionKind(dbSyncer).Kind
+		if filter.Kind != "" && !strings.EqualFold(filter.Kind, kind) {
+			continue
+		}
+
+		kindFound = true
+		differ := &syncerDiffer{
+			dbSyncer:  dbSyncer,
+			store:     store,
+			k8sReader: k8sClient,
+			k8sClient: newDryRunClient(k8sClient, syncerName, collector),
+			// the stale leaf hubs annotations are only written if staleness is not ignored
+			annotateStaleLeafHubs: config.StaleLeafHubThreshold > 0,
+			options: &aggregationOptions{
+				staleLeafHubs:      staleLeafHubs,
+				staleLeafHubPolicy: config.StaleLeafHubPolicy,
+				duplicateClustersStrategy: effectiveDuplicateClustersStrategy(config.DuplicateClustersStrategy,
+					config.QualifyClusterNames),
+				qualifyClusterNames: config.QualifyClusterNames,
+			},
+		}
+
+		compared, err := differ.diff(ctx, kind, filter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to diff DB syncer %s: %w", syncerName, err)
+		}
+
+		result.Compared += compared
+	}
+
+	if filter.Kind != "" && !kindFound {
+		return nil, fmt.Errorf("%w: %s", errUnknownDiffKind, filter.Kind)
+	}
+
+	result.Changes = append(result.Changes, collector.changes...)
+
+	return result, nil
+}
+
+// syncerDiffer applies the aggregated statuses of a DBSyncer with a dry-run client.
+type syncerDiffer struct {
+	dbSyncer  DBSyncer
+	store     Store
+	k8sReader client.Reader
+	k8sClient *dryRunClient
+	// annotateStaleLeafHubs is whether the stale leaf hubs annotations of the spec objects are compared.
+	annotateStaleLeafHubs bool
+	options               *aggregationOptions
+}
+
+// diff applies the aggregated statuses of the selected spec objects and annotates their stale leaf hubs, returns the
+// number of compared objects.
+func (differ *syncerDiffer) diff(ctx context.Context, kind string, filter *DiffFilter) (int, error) {
+	allObjects, err := differ.dbSyncer.ListSpecObjects(ctx, differ.store, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list spec objects - %w", err)
+	}
+
+	var objects []*SpecObject
+
+	for _, object := range allObjects {
+		if filter.matches(kind, object) {
+			objects = append(objects, object)
+		}
+	}
+
+	compared := 0
+
+	for start := 0; start < len(objects); start += specObjectsPageSize {
+		end := start + specObjectsPageSize
+		if end > len(objects) {
+			end = len(objects)
+		}
+
+		pageCompared, err := differ.diffPage(ctx, objects[start:end])
+		if err != nil {
+			return 0, err
+		}
+
+		compared += pageCompared
+	}
+
+	return compared, nil
+}
+
+func (differ *syncerDiffer) diffPage(ctx context.Context, objects []*SpecObject) (int, error) {
+	leafHubStatuses, err := differ.dbSyncer.FetchStatuses(ctx, differ.store, objects)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch statuses - %w", err)
+	}
+
+	options := *differ.options
+
+	if _, ok := differ.dbSyncer.(ClusterListingDBSyncer); ok &&
+		options.duplicateClustersStrategy == DuplicateClustersPreferMostRecent {
+		statusTableName, statusKeyExpression := differ.dbSyncer.StatusTable()
+
+		options.leafHubUpdateTimes, err = differ.store.LeafHubUpdateTimes(ctx, statusTableName, statusKeyExpression,
+			specObjectKeys(objects))
+		if err != nil {
+			return 0, fmt.Errorf("failed to get leaf hub update times - %w", err)
+		}
+	}
+
+	compared := 0
+
+	for _, object := range objects {
+		aggregation := aggregateObjectStatus(differ.dbSyncer, object,
+			validLeafHubStatuses(leafHubStatuses[object.Key]), &options)
+		if aggregation.status == nil && len(aggregation.staleLeafHubNames) == 0 { // nothing to apply
+			continue
+		}
+
+		if aggregation.status != nil {
+			if _, err := differ.dbSyncer.Apply(ctx, differ.k8sClient, object, aggregation.status); err != nil {
+				return 0, fmt.Errorf("failed to compare status {name=%s, namespace=%s} - %w", object.Name,
+					object.Namespace, err)
+			}
+		}
+
+		if differ.annotateStaleLeafHubs {
+			gvk := differ.dbSyncer.SpecGroupVersionKind()
+
+			if err := updateStaleLeafHubsAnnotation(ctx, differ.k8sReader, differ.k8sClient,
+				eventTarget(gvk.GroupVersion().String(), gvk.Kind, object.Name, object.Namespace, object.UID),
+				aggregation.staleLeafHubNames); err != nil {
+				return 0, fmt.Errorf("failed to compare stale leaf hubs {name=%s, namespace=%s} - %w", object.Name,
+					object.Namespace, err)
+			}
+		}
+
+		compared++
+	}
+
+	return compared, nil
+}
+
+// validLeafHubStatuses returns the statuses that could be read.
+func validLeafHubStatuses(leafHubStatuses []*LeafHubStatus) []*LeafHubStatus {
+	var validStatuses []*LeafHubStatus
+
+	for _, leafHubStatus := range leafHubStatuses {
+		if leafHubStatus.Err == nil {
+			validStatuses = append(validStatuses, leafHubStatus)
+		}
+	}
+
+	return validStatuses
+}
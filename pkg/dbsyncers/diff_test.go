@@ -0,0 +1,72 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	clustersv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDiffMatchesTargetKindAndHandlesStaleLeafHubs(t *testing.T) {
+	store := NewMemoryStore()
+	store.AddSpecRow(placementsSpecTableName, &MemorySpecRow{
+		ID:      testUID,
+		Payload: marshalPayload(t, map[string]interface{}{"metadata": objectMeta("spec")}),
+	})
+
+	for leafHubName, clusterName := range map[string]string{"hub1": "cluster1", "hub2": "cluster2"} {
+		store.AddStatusRow(placementDecisionsStatusTableName, &MemoryStatusRow{
+			LeafHubName: leafHubName,
+			Payload:     marshalPayload(t, envtestPlacementDecision(clusterName)),
+		})
+	}
+
+	store.SetLeafHubHeartbeat("hub1", time.Now())
+	store.SetLeafHubHeartbeat("hub2", time.Now().Add(-time.Hour))
+
+	k8sClient := fake.NewClientBuilder().WithScheme(newTestScheme(t)).
+		WithObjects(&clustersv1beta1.Placement{ObjectMeta: objectMeta("spec")}).Build()
+	config := &Config{
+		StaleLeafHubThreshold:     time.Minute,
+		StaleLeafHubPolicy:        StaleLeafHubPolicyDrop,
+		DuplicateClustersStrategy: DuplicateClustersKeepAll,
+	}
+
+	result, err := Diff(context.Background(), nil, k8sClient, store, NewDefaultRegistry(), config,
+		&DiffFilter{Kind: "placementdecision"})
+	if err != nil {
+		t.Fatalf("failed to diff: %v", err)
+	}
+
+	if result.Compared != 1 || len(result.Changes) != 2 {
+		t.Fatalf("unexpected diff: compared %d, changes %+v", result.Compared, result.Changes)
+	}
+
+	for _, change := range result.Changes {
+		switch change.Kind {
+		case placementDecisionKind:
+			if diff := string(change.Diff); !strings.Contains(diff, "cluster1") || strings.Contains(diff, "cluster2") {
+				t.Errorf("decision of the stale leaf hub was not dropped: %s", diff)
+			}
+		case placementKind:
+			if diff := string(change.Diff); !strings.Contains(diff, staleLeafHubsAnnotation) {
+				t.Errorf("stale leaf hubs are not annotated: %s", diff)
+			}
+		default:
+			t.Errorf("unexpected change of kind %s", change.Kind)
+		}
+	}
+
+	_, err = Diff(context.Background(), nil, k8sClient, store, NewDefaultRegistry(), config,
+		&DiffFilter{Kind: subscriptionKind})
+	if !errors.Is(err, errUnknownDiffKind) {
+		t.Errorf("diff of kind %s returned %v, want %v", subscriptionKind, err, errUnknownDiffKind)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+const (
+	dryRunFilePermissions = 0o600
+	subresourceStatus     = "status"
+)
+
+// StatusChange is a write the DB syncers would have made, the diff is the JSON merge patch from the deployed CR to
+// the aggregated status, or the whole CR if it would have been created.
+type StatusChange struct {
+	Syncer      string          `json:"syncer"`
+	Operation   string          `json:"operation"`
+	APIVersion  string          `json:"apiVersion"`
+	Kind        string          `json:"kind"`
+	Name        string          `json:"name"`
+	Namespace   string          `json:"namespace,omitempty"`
+	Subresource string          `json:"subresource,omitempty"`
+	Diff        json.RawMessage `json:"diff"`
+}
+
+// statusChangeReporter reports the writes the DB syncers would have made.
+type statusChangeReporter interface {
+	report(change *StatusChange)
+}
+
+// dryRunReporter logs the changes the DB syncers would have made, and writes them as JSON lines to a file if set.
+type dryRunReporter struct {
+	log  logr.Logger
+	lock sync.Mutex
+	// file is nil if the changes are only logged, or once the reporter is stopped.
+	file    *os.File
+	encoder *json.Encoder
+}
+
+func newDryRunReporter(log logr.Logger, filePath string) (*dryRunReporter, error) {
+	reporter := &dryRunReporter{log: log}
+
+	if filePath == "" {
+		return reporter, nil
+	}
+
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, dryRunFilePermissions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dry-run file %s - %w", filePath, err)
+	}
+
+	reporter.file = file
+	reporter.encoder = json.NewEncoder(file)
+
+	return reporter, nil
+}
+
+// Start closes the file once the context is cancelled.
+func (reporter *dryRunReporter) Start(ctx context.Context) error {
+	<-ctx.Done()
+
+	reporter.lock.Lock()
+	defer reporter.lock.Unlock()
+
+	if reporter.file == nil {
+		return nil
+	}
+
+	err := reporter.file.Close()
+	reporter.file = nil
+
+	if err != nil {
+		return fmt.Errorf("failed to close dry-run file - %w", err)
+	}
+
+	return nil
+}
+
+func (reporter *dryRunReporter) report(change *StatusChange) {
+	reporter.log.Info("would change object (dry-run)", "syncer", change.Syncer, "operation", change.Operation,
+		"kind", change.Kind, "name", change.Name, "namespace", change.Namespace, "subresource", change.Subresource,
+		"diff", string(change.Diff))
+
+	reporter.lock.Lock()
+	defer reporter.lock.Unlock()
+
+	if reporter.file == nil {
+		return
+	}
+
+	if err := reporter.encoder.Encode(change); err != nil {
+		reporter.log.Error(err, "failed to write dry-run change")
+	}
+}
+
+// dryRunClient reports the creations and patches of the DBSyncers instead of sending them. reads are sent as usual.
+type dryRunClient struct {
+	client.Client
+	syncerName string
+	reporter   statusChangeReporter
+}
+
+func newDryRunClient(k8sClient client.Client, syncerName string, reporter statusChangeReporter) *dryRunClient {
+	return &dryRunClient{Client: k8sClient, syncerName: syncerName, reporter: reporter}
+}
+
+func (k8sClient *dryRunClient) Create(_ context.Context, object client.Object, _ ...client.CreateOption) error {
+	diff, err := json.Marshal(object)
+	if err != nil {
+		return fmt.Errorf("failed to marshal object {name=%s, namespace=%s} - %w", object.GetName(),
+			object.GetNamespace(), err)
+	}
+
+	return k8sClient.report(operationCreate, "", object, diff)
+}
+
+func (k8sClient *dryRunClient) Patch(_ context.Context, object client.Object, patch client.Patch,
+	_ ...client.PatchOption) error {
+	return k8sClient.reportPatch("", object, patch)
+}
+
+// Status returns a StatusWriter that reports the status patches instead of sending them.
+func (k8sClient *dryRunClient) Status() client.StatusWriter {
+	return &dryRunStatusWriter{StatusWriter: k8sClient.Client.Status(), k8sClient: k8sClient}
+}
+
+func (k8sClient *dryRunClient) reportPatch(subresource string, object client.Object, patch client.Patch) error {
+	diff, err := patch.Data(object)
+	if err != nil {
+		return fmt.Errorf("failed to compute patch of object {name=%s, namespace=%s} - %w", object.GetName(),
+			object.GetNamespace(), err)
+	}
+
+	return k8sClient.report(operationPatch, subresource, object, diff)
+}
+
+func (k8sClient *dryRunClient) report(operation string, subresource string, object client.Object,
+	diff []byte) error {
+	gvk, err := apiutil.GVKForObject(object, k8sClient.Scheme())
+	if err != nil {
+		return fmt.Errorf("failed to get kind of object {name=%s, namespace=%s} - %w", object.GetName(),
+			object.GetNamespace(), err)
+	}
+
+	k8sClient.reporter.report(&StatusChange{
+		Syncer:      k8sClient.syncerName,
+		Operation:   operation,
+		APIVersion:  gvk.GroupVersion().String(),
+		Kind:        gvk.Kind,
+		Name:        object.GetName(),
+		Namespace:   object.GetNamespace(),
+		Subresource: subresource,
+		Diff:        diff,
+	})
+
+	return nil
+}
+
+// dryRunStatusWriter reports the status patches instead of sending them.
+type dryRunStatusWriter struct {
+	client.StatusWriter
+	k8sClient *dryRunClient
+}
+
+func (statusWriter *dryRunStatusWriter) Patch(_ context.Context, object client.Object, patch client.Patch,
+	_ ...client.PatchOption) error {
+	return statusWriter.k8sClient.reportPatch(subresourceStatus, object, patch)
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DuplicateClustersStrategy is the way the clusters reported by more than one leaf hub are aggregated, for example
+// while a cluster migrates between leaf hubs.
+type DuplicateClustersStrategy string
+
+const (
+	// DuplicateClustersKeepAll keeps the cluster as reported by every leaf hub.
+	DuplicateClustersKeepAll DuplicateClustersStrategy = "keepAll"
+	// DuplicateClustersPreferMostRecent keeps the cluster as reported by the leaf hub that updated its status rows of
+	// the object most recently, it requires the updated_at column of the status tables.
+	DuplicateClustersPreferMostRecent DuplicateClustersStrategy = "preferMostRecent"
+	// DuplicateClustersQualify keeps the cluster as reported by every leaf hub, with its name qualified by the name
+	// of the leaf hub.
+	DuplicateClustersQualify DuplicateClustersStrategy = "qualify"
+)
+
+// ClusterListingDBSyncer is implemented by the DBSyncers whose statuses list clusters, so that the clusters reported
+// by more than one leaf hub can be detected and resolved.
+type ClusterListingDBSyncer interface {
+	// ClusterNames returns the names of the clusters in a status reported by a leaf hub.
+	ClusterNames(leafHubStatus interface{}) []string
+	// RewriteClusters returns a copy of a status reported by a leaf hub, with each cluster renamed or dropped by
+	// rewrite. returns nil if the status has no clusters left and should not be aggregated.
+	RewriteClusters(leafHubStatus interface{},
+		rewrite func(clusterName string) (newClusterName string, keep bool)) interface{}
+}
+
+func qualifiedClusterName(leafHubName string, clusterName string) string {
+	return fmt.Sprintf("%s/%s", leafHubName, clusterName)
+}
+
+// resolveDuplicateClusters resolves the clusters reported by more than one leaf hub by the strategy. leafHubUpdateTimes
+// holds the last update time of the statuses of each leaf hub, it is only used by preferMostRecent. returns the
+// statuses to aggregate and the sorted duplicate clusters.
+func resolveDuplicateClusters(lister ClusterListingDBSyncer, strategy DuplicateClustersStrategy,
+	leafHubStatuses []*LeafHubStatus, leafHubUpdateTimes map[string]time.Time) ([]*LeafHubStatus, []string) {
+	clusterLeafHubs := map[string]map[string]struct{}{}
+
+	for _, leafHubStatus := range leafHubStatuses {
+		for _, clusterName := range lister.ClusterNames(leafHubStatus.Status) {
+			if _, found := clusterLeafHubs[clusterName]; !found {
+				clusterLeafHubs[clusterName] = map[string]struct{}{}
+			}
+
+			clusterLeafHubs[clusterName][leafHubStatus.LeafHubName] = struct{}{}
+		}
+	}
+
+	// the leaf hub whose report of each duplicate cluster is kept by preferMostRecent
+	preferredLeafHubs := map[string]string{}
+
+	for clusterName, leafHubNames := range clusterLeafHubs {
+		if len(leafHubNames) > 1 {
+			preferredLeafHubs[clusterName] = mostRecentLeafHub(leafHubNames, leafHubUpdateTimes)
+		}
+	}
+
+	duplicateClusters := make([]string, 0, len(preferredLeafHubs))
+	for clusterName := range preferredLeafHubs {
+		duplicateClusters = append(duplicateClusters, clusterName)
+	}
+
+	sort.Strings(duplicateClusters)
+
+	if len(duplicateClusters) == 0 || strategy == DuplicateClustersKeepAll {
+		return leafHubStatuses, duplicateClusters
+	}
+
+	resolvedStatuses := make([]*LeafHubStatus, 0, len(leafHubStatuses))
+
+	for _, leafHubStatus := range leafHubStatuses {
+		leafHubName := leafHubStatus.LeafHubName
+
+		status := lister.RewriteClusters(leafHubStatus.Status, func(clusterName string) (string, bool) {
+			preferredLeafHub, duplicate := preferredLeafHubs[clusterName]
+			if !duplicate {
+				return clusterName, true
+			}
+
+			if strategy == DuplicateClustersQualify {
+				return qualifiedClusterName(leafHubName, clusterName), true
+			}
+
+			return clusterName, preferredLeafHub == leafHubName
+		})
+		if status == nil {
+			continue
+		}
+
+		resolvedStatuses = append(resolvedStatuses, &LeafHubStatus{LeafHubName: leafHubName, Status: status})
+	}
+
+	return resolvedStatuses, duplicateClusters
+}
+
+// queryLeafHubUpdateTimes returns the duplicate clusters strategy of a page of objects, with the leaf hub update times
+// if it is preferMostRecent. falls back to keepAll if the update times cannot be read.
+func (syncer *genericDBSyncer) queryLeafHubUpdateTimes(ctx context.Context,
+	objects []*SpecObject) (DuplicateClustersStrategy, map[string]map[string]time.Time) {
+	if syncer.duplicateClustersStrategy != DuplicateClustersPreferMostRecent {
+		return syncer.duplicateClustersStrategy, nil
+	}
+
+	if _, ok := syncer.dbSyncer.(ClusterListingDBSyncer); !ok {
+		return syncer.duplicateClustersStrategy, nil
+	}
+
+	leafHubUpdateTimes, err := syncer.store.LeafHubUpdateTimes(ctx, syncer.statusTableName,
+		syncer.statusKeyExpression, specObjectKeys(objects))
+	if err != nil {
+		syncer.metrics.countDBError()
+		syncer.log.Error(err, "failed to get leaf hub update times, keeping all the duplicate clusters")
+
+		return DuplicateClustersKeepAll, nil
+	}
+
+	return syncer.duplicateClustersStrategy, leafHubUpdateTimes
+}
+
+// mostRecentLeafHub returns the leaf hub with the latest update time, the first by name on a tie.
+func mostRecentLeafHub(leafHubNames map[string]struct{}, leafHubUpdateTimes map[string]time.Time) string {
+	var (
+		mostRecent           string
+		mostRecentUpdateTime time.Time
+	)
+
+	for leafHubName := range leafHubNames {
+		updateTime := leafHubUpdateTimes[leafHubName]
+
+		if mostRecent == "" || updateTime.After(mostRecentUpdateTime) ||
+			(updateTime.Equal(mostRecentUpdateTime) && leafHubName < mostRecent) {
+			mostRecent, mostRecentUpdateTime = leafHubName, updateTime
+		}
+	}
+
+	return mostRecent
+}
+
+// sortedUnion returns the sorted values that are in any of the slices, without duplicates.
+func sortedUnion(values1 []string, values2 []string) []string {
+	union := make(map[string]struct{}, len(values1)+len(values2))
+
+	for _, value := range append(append([]string{}, values1...), values2...) {
+		union[value] = struct{}{}
+	}
+
+	sortedValues := make([]string, 0, len(union))
+	for value := range union {
+		sortedValues = append(sortedValues, value)
+	}
+
+	sort.Strings(sortedValues)
+
+	return sortedValues
+}
@@ -0,0 +1,268 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	policiesv1 "github.com/open-cluster-management/governance-policy-propagator/api/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clustersv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+	placementrulesv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/placementrule/v1"
+	appsv1alpha1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1alpha1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+// envtestTimeout bounds the start of the API server and the sync of the DB syncers.
+const envtestTimeout = 2 * time.Minute
+
+// TestDBSyncersEnvtest syncs the statuses of a MemoryStore once by all the built-in DB syncers against the API server
+// of envtest, with the CRDs of testdata/crds, and checks the statuses they wrote. it is skipped unless
+// KUBEBUILDER_ASSETS points to the envtest binaries, e.g. as installed by setup-envtest.
+func TestDBSyncersEnvtest(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set, the envtest binaries are required")
+	}
+
+	testEnv := &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("testdata", "crds")},
+		ErrorIfCRDPathMissing: true,
+	}
+
+	restConfig, err := testEnv.Start()
+	if err != nil {
+		t.Fatalf("failed to start envtest: %v", err)
+	}
+
+	defer func() {
+		if err := testEnv.Stop(); err != nil {
+			t.Errorf("failed to stop envtest: %v", err)
+		}
+	}()
+
+	scheme := newTestScheme(t)
+
+	k8sClient, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	ctx, cancelContext := context.WithTimeout(context.Background(), envtestTimeout)
+	defer cancelContext()
+
+	// the DB syncers patch the statuses of the spec objects, and create the other CRs
+	for _, object := range []client.Object{
+		&policiesv1.Policy{
+			ObjectMeta: objectMeta("spec"),
+			Spec:       policiesv1.PolicySpec{RemediationAction: policiesv1.Inform},
+		},
+		&placementrulesv1.PlacementRule{ObjectMeta: objectMeta("spec")},
+		&clustersv1beta1.Placement{ObjectMeta: objectMeta("spec")},
+	} {
+		if err := k8sClient.Create(ctx, object); err != nil {
+			t.Fatalf("failed to create %s: %v", object.GetName(), err)
+		}
+	}
+
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{Scheme: scheme, MetricsBindAddress: "0"})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+
+	report := NewOnceReport()
+
+	if err := AddDBSyncers(mgr, newEnvtestStore(t), NewDefaultRegistry(), &Config{
+		SyncInterval:              time.Second,
+		WorkerPoolSize:            4,
+		SyncerConcurrency:         2,
+		HealthTickMultiple:        3,
+		ShutdownGracePeriod:       time.Second,
+		DuplicateClustersStrategy: DuplicateClustersKeepAll,
+		Once:                      report,
+		MetricsRegisterer:         prometheus.NewRegistry(),
+	}); err != nil {
+		t.Fatalf("failed to add DB syncers: %v", err)
+	}
+
+	managerContext, cancelManager := context.WithCancel(ctx)
+	defer cancelManager()
+
+	managerErrors := make(chan error, 1)
+
+	go func() { managerErrors <- mgr.Start(managerContext) }()
+
+	select {
+	case <-report.Done():
+	case err := <-managerErrors:
+		t.Fatalf("manager exited before the DB syncers synced: %v", err)
+	case <-ctx.Done():
+		t.Fatalf("DB syncers did not sync within %s", envtestTimeout)
+	}
+
+	cancelManager()
+
+	if err := <-managerErrors; err != nil {
+		t.Errorf("manager exited non-zero: %v", err)
+	}
+
+	for _, summary := range report.Summaries() {
+		if !summary.Succeeded() || summary.Synced != 1 {
+			t.Errorf("unexpected summary of DB syncer %s: %+v", summary.Syncer, summary)
+		}
+	}
+
+	checkEnvtestStatuses(t, k8sClient)
+}
+
+// newEnvtestStore returns a MemoryStore with a spec row of each spec table and the status rows of two leaf hubs.
+func newEnvtestStore(t *testing.T) *MemoryStore {
+	t.Helper()
+
+	store := NewMemoryStore()
+
+	for _, specTableName := range []string{
+		policiesSpecTableName, placementRulesSpecTableName, placementsSpecTableName, subscriptionsSpecTableName,
+	} {
+		store.AddSpecRow(specTableName, &MemorySpecRow{
+			ID:      testUID,
+			Payload: marshalPayload(t, map[string]interface{}{"metadata": objectMeta("spec")}),
+		})
+	}
+
+	store.AddStatusRow(complianceStatusTableName, &MemoryStatusRow{
+		ID: testUID, LeafHubName: "hub1", ClusterName: "cluster1", Compliance: dbEnumCompliant,
+	})
+	store.AddStatusRow(complianceStatusTableName, &MemoryStatusRow{
+		ID: testUID, LeafHubName: "hub2", ClusterName: "cluster2", Compliance: dbEnumNonCompliant,
+	})
+
+	for leafHubName, clusterName := range map[string]string{"hub1": "cluster1", "hub2": "cluster2"} {
+		for statusTableName, status := range map[string]interface{}{
+			placementrRulesStatusTableName:     envtestPlacementRule(clusterName),
+			placementsStatusTableName:          envtestPlacement(),
+			placementDecisionsStatusTableName:  envtestPlacementDecision(clusterName),
+			subscriptionStatusesTableName:      envtestSubscriptionStatus(clusterName),
+			subscriptionReportsStatusTableName: envtestSubscriptionReport(clusterName),
+		} {
+			store.AddStatusRow(statusTableName, &MemoryStatusRow{
+				LeafHubName: leafHubName,
+				Payload:     marshalPayload(t, status),
+			})
+		}
+	}
+
+	return store
+}
+
+func envtestPlacementRule(clusterName string) *placementrulesv1.PlacementRule {
+	placementRule := &placementrulesv1.PlacementRule{ObjectMeta: objectMeta("spec")}
+	placementRule.Status.Decisions = []placementrulesv1.PlacementDecision{
+		{ClusterName: clusterName, ClusterNamespace: clusterName},
+	}
+
+	return placementRule
+}
+
+func envtestPlacement() *clustersv1beta1.Placement {
+	return &clustersv1beta1.Placement{
+		ObjectMeta: objectMeta("spec"),
+		Status:     clustersv1beta1.PlacementStatus{NumberOfSelectedClusters: 1},
+	}
+}
+
+func envtestPlacementDecision(clusterName string) *clustersv1beta1.PlacementDecision {
+	placementDecision := &clustersv1beta1.PlacementDecision{ObjectMeta: objectMeta("spec-decision-1")}
+	placementDecision.Labels = map[string]string{clustersv1beta1.PlacementLabel: "spec"}
+	placementDecision.Status.Decisions = []clustersv1beta1.ClusterDecision{{ClusterName: clusterName}}
+
+	return placementDecision
+}
+
+func envtestSubscriptionStatus(clusterName string) *appsv1alpha1.SubscriptionStatus {
+	subscriptionStatus := &appsv1alpha1.SubscriptionStatus{ObjectMeta: objectMeta("spec")}
+	subscriptionStatus.Statuses.SubscriptionStatus = []appsv1alpha1.SubscriptionUnitStatus{{
+		Name:           clusterName + "-package",
+		Kind:           "Deployment",
+		Phase:          "Deployed",
+		LastUpdateTime: metav1.NewTime(time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)),
+	}}
+
+	return subscriptionStatus
+}
+
+func envtestSubscriptionReport(clusterName string) *appsv1alpha1.SubscriptionReport {
+	subscriptionReport := &appsv1alpha1.SubscriptionReport{ObjectMeta: objectMeta("spec"), ReportType: "Application"}
+	subscriptionReport.Summary.Deployed = "1"
+	subscriptionReport.Summary.Clusters = "1"
+	subscriptionReport.Results = []*appsv1alpha1.SubscriptionReportResult{
+		{Source: clusterName, Result: subscriptionResultDeployed},
+	}
+
+	return subscriptionReport
+}
+
+// checkEnvtestStatuses checks the statuses aggregated from the status rows of newEnvtestStore.
+func checkEnvtestStatuses(t *testing.T, k8sClient client.Client) {
+	t.Helper()
+
+	policy := &policiesv1.Policy{}
+	getObject(t, k8sClient, "spec", policy)
+
+	if policy.Status.ComplianceState != policiesv1.NonCompliant || len(policy.Status.Status) != 2 {
+		t.Errorf("unexpected policy status: %+v", policy.Status)
+	}
+
+	placementRule := &placementrulesv1.PlacementRule{}
+	getObject(t, k8sClient, "spec", placementRule)
+
+	if len(placementRule.Status.Decisions) != 2 {
+		t.Errorf("unexpected placement-rule decisions: %+v", placementRule.Status.Decisions)
+	}
+
+	placement := &clustersv1beta1.Placement{}
+	getObject(t, k8sClient, "spec", placement)
+
+	if placement.Status.NumberOfSelectedClusters != 2 {
+		t.Errorf("number of selected clusters is %d, want 2", placement.Status.NumberOfSelectedClusters)
+	}
+
+	// the status subresource of placement-decisions is written after their creation
+	placementDecision := &clustersv1beta1.PlacementDecision{}
+	getObject(t, k8sClient, "spec-decision-1", placementDecision)
+
+	if clusterNames := placementDecisionClusterNames(placementDecision); len(clusterNames) != 2 {
+		t.Errorf("unexpected placement-decision decisions: %v", clusterNames)
+	}
+
+	if controllerUID(placementDecision) != testUID {
+		t.Errorf("placement-decision is not owned by its placement: %+v", placementDecision.OwnerReferences)
+	}
+
+	subscriptionStatus := &appsv1alpha1.SubscriptionStatus{}
+	getObject(t, k8sClient, "spec", subscriptionStatus)
+
+	if packages := subscriptionStatus.Statuses.SubscriptionStatus; len(packages) != 2 {
+		t.Errorf("unexpected subscription-status packages: %+v", packages)
+	}
+
+	if controllerUID(subscriptionStatus) != testUID {
+		t.Errorf("subscription-status is not owned by its subscription: %+v", subscriptionStatus.OwnerReferences)
+	}
+
+	subscriptionReport := &appsv1alpha1.SubscriptionReport{}
+	getObject(t, k8sClient, "spec", subscriptionReport)
+
+	if subscriptionReport.Summary.Deployed != "2" || subscriptionReport.Summary.Clusters != "2" ||
+		len(subscriptionReport.Results) != 2 {
+		t.Errorf("unexpected subscription-report: summary %+v, results %d", subscriptionReport.Summary,
+			len(subscriptionReport.Results))
+	}
+}
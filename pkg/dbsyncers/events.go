@@ -0,0 +1,90 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// reasons of the Events recorded on the synced CRs.
+const (
+	eventReasonDBReadFailed        = "StatusDBReadFailed"
+	eventReasonInvalidStatus       = "StatusInvalid"
+	eventReasonUpdateFailed        = "StatusUpdateFailed"
+	eventReasonAggregationConflict = "StatusAggregationConflict"
+)
+
+const eventRateLimitInterval = 5 * time.Minute
+
+// syncerEvents records warning Events on the synced CRs. a persistent failure is recorded at most once per CR and
+// reason every eventRateLimitInterval.
+type syncerEvents struct {
+	recorder     record.EventRecorder
+	lock         sync.Mutex
+	lastRecorded map[string]time.Time
+	lastPruned   time.Time
+}
+
+func newSyncerEvents(recorder record.EventRecorder) *syncerEvents {
+	return &syncerEvents{
+		recorder:     recorder,
+		lastRecorded: map[string]time.Time{},
+		lastPruned:   time.Now(),
+	}
+}
+
+// eventTarget returns an object that identifies a CR as the target of an Event, without fetching it.
+func eventTarget(apiVersion string, kind string, name string, namespace string, uid string) client.Object {
+	return &v1.PartialObjectMetadata{
+		TypeMeta: v1.TypeMeta{APIVersion: apiVersion, Kind: kind},
+		ObjectMeta: v1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			UID:       types.UID(uid),
+		},
+	}
+}
+
+// warning records a warning Event on the object, unless one with the same reason was recorded recently.
+func (events *syncerEvents) warning(object client.Object, reason string, messageFormat string,
+	args ...interface{}) {
+	if !events.allow(fmt.Sprintf("%s/%s/%s/%s", object.GetNamespace(), object.GetName(), object.GetUID(), reason)) {
+		return
+	}
+
+	events.recorder.Eventf(object, corev1.EventTypeWarning, reason, messageFormat, args...)
+}
+
+func (events *syncerEvents) allow(key string) bool {
+	events.lock.Lock()
+	defer events.lock.Unlock()
+
+	now := time.Now()
+
+	if now.Sub(events.lastPruned) > eventRateLimitInterval { // forget the expired entries of deleted CRs
+		for recordedKey, recordedAt := range events.lastRecorded {
+			if now.Sub(recordedAt) > eventRateLimitInterval {
+				delete(events.lastRecorded, recordedKey)
+			}
+		}
+
+		events.lastPruned = now
+	}
+
+	if recordedAt, found := events.lastRecorded[key]; found && now.Sub(recordedAt) < eventRateLimitInterval {
+		return false
+	}
+
+	events.lastRecorded[key] = now
+
+	return true
+}
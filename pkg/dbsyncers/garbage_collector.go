@@ -0,0 +1,118 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// garbageCollectedSyncer is a DB syncer whose CRs are garbage collected.
+type garbageCollectedSyncer struct {
+	name     string
+	dbSyncer GarbageCollectedDBSyncer
+	metrics  *syncerMetrics
+	// genericSyncer runs the DB syncer, the CRs of a paused DB syncer are not collected.
+	genericSyncer *genericDBSyncer
+}
+
+// garbageCollector periodically deletes the CRs generated by the DB syncers, or clears the status they wrote to spec
+// objects, once the spec objects are deleted or missing. in dry-run, the CRs are only logged and counted.
+type garbageCollector struct {
+	log       logr.Logger
+	specStore SpecStore
+	k8sClient client.Client
+	// k8sReader reads directly from the API server, so that no informers are started for the collected kinds.
+	k8sReader client.Reader
+	interval  time.Duration
+	dryRun    bool
+	syncers   []*garbageCollectedSyncer
+}
+
+// Start runs the garbage collection until the context is cancelled.
+func (gc *garbageCollector) Start(ctx context.Context) error {
+	ticker := time.NewTicker(gc.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done(): // we have received a signal to stop
+			return nil
+
+		case <-ticker.C:
+			for _, syncer := range gc.syncers {
+				if !syncer.genericSyncer.isPaused() {
+					gc.collect(ctx, syncer)
+				}
+			}
+		}
+	}
+}
+
+func (gc *garbageCollector) collect(ctx context.Context, syncer *garbageCollectedSyncer) {
+	ctxWithTimeout, cancelFunc := context.WithTimeout(ctx, gc.interval)
+	defer cancelFunc()
+
+	log := gc.log.WithValues("syncer", syncer.name)
+
+	syncedObjects, err := syncer.dbSyncer.ListSyncedObjects(ctxWithTimeout, gc.k8sReader)
+	if err != nil {
+		syncer.metrics.countGarbageCollectionError(apiOperation(err))
+		log.Error(err, "failed to list synced objects")
+
+		return
+	}
+
+	if len(syncedObjects) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(syncedObjects))
+	for _, syncedObject := range syncedObjects {
+		keys = append(keys, syncedObject.Key)
+	}
+
+	specObjects, err := syncer.dbSyncer.ListSpecObjects(ctxWithTimeout, gc.specStore, keys)
+	if err != nil {
+		syncer.metrics.countGarbageCollectionError(operationQuery)
+		log.Error(err, "failed to list spec objects")
+
+		return
+	}
+
+	specObjectUIDs := make(map[string]string, len(specObjects))
+	for _, specObject := range specObjects {
+		specObjectUIDs[specObject.Key] = specObject.UID
+	}
+
+	for _, syncedObject := range syncedObjects {
+		uid, found := specObjectUIDs[syncedObject.Key]
+		if found && (syncedObject.OwnerUID == "" || syncedObject.OwnerUID == uid) {
+			continue
+		}
+
+		objectLog := log.WithValues("name", syncedObject.Object.GetName(),
+			"namespace", syncedObject.Object.GetNamespace(), "specObjectFound", found)
+
+		if gc.dryRun {
+			objectLog.Info("would garbage collect object (dry-run)")
+			syncer.metrics.countGarbageCollected(true)
+
+			continue
+		}
+
+		if err := syncer.dbSyncer.Collect(ctxWithTimeout, gc.k8sClient, syncedObject.Object); err != nil {
+			syncer.metrics.countGarbageCollectionError(apiOperation(err))
+			objectLog.Error(err, "failed to garbage collect object")
+
+			continue
+		}
+
+		objectLog.Info("garbage collected object")
+		syncer.metrics.countGarbageCollected(false)
+	}
+}
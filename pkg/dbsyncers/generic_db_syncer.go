@@ -5,53 +5,504 @@ package dbsyncers
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-type genericDBSyncer struct {
+// abandonedHandlersTimeout is the time the handlers that were abandoned on shutdown have to return, once their context
+// is cancelled.
+const abandonedHandlersTimeout = 5 * time.Second
+
+// dbSyncerConfig holds the configuration AddDBSyncers provides to each DB syncer.
+type dbSyncerConfig struct {
+	name         string
+	log          logr.Logger
+	metrics      *syncerMetrics
+	events       *syncerEvents
 	syncInterval time.Duration
-	syncFunc     func(ctx context.Context)
+	// fullSyncInterval is the interval of full syncs in the incremental mode, zero if the mode is disabled.
+	fullSyncInterval time.Duration
+	workers          *syncerWorkers
+	// listener is nil unless the DB syncers are event-driven.
+	listener            *dbListener
+	health              *dbSyncersHealth
+	shutdownGracePeriod time.Duration
+	// staleLeafHubThreshold is the age of the last heartbeat of a stale leaf hub, zero if staleness is ignored.
+	staleLeafHubThreshold     time.Duration
+	staleLeafHubPolicy        StaleLeafHubPolicy
+	duplicateClustersStrategy DuplicateClustersStrategy
+	qualifyClusterNames       bool
+	serverSideApply           bool
+	forceOwnership            bool
+	retryPolicy               *retryPolicy
+	// dryRunReporter is nil unless the DB syncers run in dry-run.
+	dryRunReporter *dryRunReporter
+	// onceReport is nil unless the DB syncers sync once.
+	onceReport *OnceReport
+	// paused is set for the disabled DB syncers that can be enabled by a configuration reload.
+	paused bool
+}
+
+// genericDBSyncer runs a DBSyncer, it handles the periodic sync, the concurrency, the metrics and the Events.
+type genericDBSyncer struct {
+	log       logr.Logger
+	metrics   *syncerMetrics
+	events    *syncerEvents
+	dbSyncer  DBSyncer
+	store     Store
+	k8sClient client.Client
+	// k8sReader reads the deployed stale leaf hubs annotations from the API server, without caching the spec kinds.
+	k8sReader client.Reader
+	// statusClient writes the aggregated statuses, by server-side apply if enabled.
+	statusClient client.Client
+	workers      *syncerWorkers
+	// notifications is nil unless the syncer is event-driven.
+	notifications *dbNotificationSubscription
+	// changedKeysFunc is nil unless the syncer is incremental.
+	changedKeysFunc  func(ctx context.Context, since time.Time) ([]string, time.Time, error)
+	watermarkFunc    func(ctx context.Context) (time.Time, error)
+	fullSyncInterval time.Duration
+	lastFullSync     time.Time
+	watermark        time.Time
+	// lastTick is the unix time in nanoseconds of the last completed iteration of the periodic sync.
+	lastTick              int64
+	shutdownGracePeriod   time.Duration
+	staleLeafHubThreshold time.Duration
+	staleLeafHubPolicy    StaleLeafHubPolicy
+	retryPolicy           *retryPolicy
+	// onceReport and summary are nil unless the syncer syncs once.
+	onceReport *OnceReport
+	summary    *SyncSummary
+	// statusTableName and statusKeyExpression are those of the DBSyncer.
+	statusTableName           string
+	statusKeyExpression       string
+	duplicateClustersStrategy DuplicateClustersStrategy
+	qualifyClusterNames       bool
+	// settingsLock guards syncInterval and paused, which can be changed by a configuration reload while the syncer
+	// runs. settingsChanged wakes up the periodic sync once they are changed.
+	settingsLock    sync.RWMutex
+	syncInterval    time.Duration
+	paused          bool
+	settingsChanged chan struct{}
+}
+
+// newGenericDBSyncer returns a runnable of the given DBSyncer.
+func newGenericDBSyncer(config *dbSyncerConfig, store Store, k8sClient client.Client, k8sReader client.Reader,
+	dbSyncer DBSyncer) *genericDBSyncer {
+	statusTableName, statusKeyExpression := dbSyncer.StatusTable()
+
+	syncer := &genericDBSyncer{
+		log:                       config.log,
+		metrics:                   config.metrics,
+		events:                    config.events,
+		syncInterval:              config.syncInterval,
+		dbSyncer:                  dbSyncer,
+		store:                     store,
+		k8sClient:                 k8sClient,
+		k8sReader:                 k8sReader,
+		statusClient:              k8sClient,
+		workers:                   config.workers,
+		shutdownGracePeriod:       config.shutdownGracePeriod,
+		notifications:             config.listener.subscribe(statusNotificationChannel(statusTableName)),
+		staleLeafHubThreshold:     config.staleLeafHubThreshold,
+		staleLeafHubPolicy:        config.staleLeafHubPolicy,
+		statusTableName:           statusTableName,
+		statusKeyExpression:       statusKeyExpression,
+		duplicateClustersStrategy: config.duplicateClustersStrategy,
+		qualifyClusterNames:       config.qualifyClusterNames,
+		retryPolicy:               config.retryPolicy,
+		paused:                    config.paused,
+		settingsChanged:           make(chan struct{}, 1),
+	}
+
+	syncer.duplicateClustersStrategy = effectiveDuplicateClustersStrategy(config.duplicateClustersStrategy,
+		config.qualifyClusterNames)
+
+	switch {
+	case config.dryRunReporter != nil: // nothing is written, the merge patches are reported as diffs
+		syncer.k8sClient = newDryRunClient(k8sClient, config.name, config.dryRunReporter)
+		syncer.statusClient = syncer.k8sClient
+	case config.serverSideApply:
+		syncer.statusClient = newServerSideApplyClient(k8sClient, config.forceOwnership)
+	}
+
+	if config.onceReport != nil {
+		syncer.onceReport = config.onceReport
+		syncer.summary = &SyncSummary{Syncer: config.name}
+	}
+
+	config.health.register(config.name, syncer)
+
+	if config.fullSyncInterval > 0 {
+		syncer.fullSyncInterval = config.fullSyncInterval
+		syncer.changedKeysFunc = func(ctx context.Context, since time.Time) ([]string, time.Time, error) {
+			return store.ChangedStatusKeys(ctx, statusTableName, statusKeyExpression, since)
+		}
+		syncer.watermarkFunc = func(ctx context.Context) (time.Time, error) {
+			return store.StatusWatermark(ctx, statusTableName)
+		}
+	}
+
+	return syncer
 }
 
+// Start runs the periodic sync until the context is cancelled. on shutdown, the syncer stops submitting handlers and
+// waits up to the shutdown grace period for the in-flight ones, which run with a context that is cancelled only once
+// the grace period passes.
 func (syncer *genericDBSyncer) Start(ctx context.Context) error {
-	ctxWithCancel, cancelContext := context.WithCancel(ctx)
-	defer cancelContext()
+	if syncer.onceReport != nil {
+		return syncer.syncOnce(ctx)
+	}
+
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
 
-	go syncer.periodicSync(ctxWithCancel)
+	defer syncer.workers.close()
+
+	syncer.recordTick() // the first tick is expected within the health tolerance from the start
+
+	periodicSyncDone := make(chan struct{})
+
+	go func() {
+		defer close(periodicSyncDone)
+		syncer.periodicSync(ctx, workCtx)
+	}()
 
 	<-ctx.Done() // blocking wait for stop event
 
-	return nil // context cancel is called before exiting this function
+	syncer.workers.stop()
+
+	select {
+	case <-periodicSyncDone:
+		return nil
+	case <-time.After(syncer.shutdownGracePeriod):
+	}
+
+	abandonedHandlers := syncer.workers.pendingJobs()
+	syncer.metrics.countAbandonedHandlers(abandonedHandlers)
+	syncer.log.Info("shutdown grace period passed, abandoning in-flight handlers",
+		"gracePeriod", syncer.shutdownGracePeriod, "abandonedHandlers", abandonedHandlers)
+
+	cancelWork()
+
+	select {
+	case <-periodicSyncDone:
+	case <-time.After(abandonedHandlersTimeout):
+		syncer.log.Info("abandoned handlers did not return in time", "timeout", abandonedHandlersTimeout)
+	}
+
+	return nil
 }
 
-func (syncer *genericDBSyncer) periodicSync(ctx context.Context) {
-	ticker := time.NewTicker(syncer.syncInterval)
+// periodicSync syncs until stopCtx is cancelled, the syncs run with workCtx.
+func (syncer *genericDBSyncer) periodicSync(stopCtx context.Context, workCtx context.Context) {
+	ticker := time.NewTicker(syncer.currentSyncInterval())
+	defer ticker.Stop()
 
-	var (
-		cancelFunc     context.CancelFunc
-		ctxWithTimeout context.Context
-	)
+	paused := syncer.isPaused()
 
 	for {
+		if stopCtx.Err() != nil { // a stop signal takes precedence over pending syncs
+			return
+		}
+
 		select {
-		case <-ctx.Done(): // we have received a signal to stop
-			ticker.Stop()
+		case <-stopCtx.Done(): // we have received a signal to stop
+			return
+
+		case <-ticker.C:
+			switch {
+			case paused: // the ticks are still recorded, so that the syncer stays healthy
+			case syncer.isIncrementalSyncDue():
+				syncer.incrementalSync(workCtx)
+			default:
+				syncer.fullSync(workCtx)
+			}
+
+		case <-syncer.settingsChanged:
+			ticker.Reset(syncer.currentSyncInterval())
+
+			wasPaused := paused
+			if paused = syncer.isPaused(); wasPaused && !paused { // the changes made while paused are synced
+				syncer.fullSync(workCtx)
+			}
+
+		case <-syncer.notifications.resyncChannel():
+			if !paused {
+				syncer.fullSync(workCtx)
+			}
 
-			if cancelFunc != nil {
-				cancelFunc()
+		case key := <-syncer.notifications.keysChannel():
+			if keys := syncer.notifications.drainKeys(key); !paused {
+				syncer.sync(workCtx, keys)
 			}
+		}
 
+		syncer.recordTick()
+	}
+}
+
+func (syncer *genericDBSyncer) recordTick() {
+	atomic.StoreInt64(&syncer.lastTick, time.Now().UnixNano())
+}
+
+// lastTickTime returns the time of the last completed tick, zero if the syncer was not started.
+func (syncer *genericDBSyncer) lastTickTime() time.Time {
+	lastTick := atomic.LoadInt64(&syncer.lastTick)
+	if lastTick == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, lastTick)
+}
+
+// isIncrementalSyncDue returns true if the syncer is incremental and the last full sync is recent enough.
+func (syncer *genericDBSyncer) isIncrementalSyncDue() bool {
+	return syncer.changedKeysFunc != nil && !syncer.lastFullSync.IsZero() &&
+		time.Since(syncer.lastFullSync) < syncer.fullSyncInterval
+}
+
+func (syncer *genericDBSyncer) fullSync(ctx context.Context) {
+	if syncer.watermarkFunc != nil {
+		// the watermark is taken before the sync, so that changes during the sync are picked by the next one
+		watermark, err := syncer.watermarkFunc(ctx)
+		if err != nil {
+			syncer.metrics.countDBError()
+			syncer.log.Error(err, "failed to get status watermark")
 			return
+		}
 
-		case <-ticker.C:
-			// cancel the operation of the previous tick
-			if cancelFunc != nil {
-				cancelFunc()
+		syncer.watermark = watermark
+	}
+
+	syncer.sync(ctx, nil)
+	syncer.lastFullSync = time.Now()
+}
+
+func (syncer *genericDBSyncer) incrementalSync(ctx context.Context) {
+	keys, watermark, err := syncer.changedKeysFunc(ctx, syncer.watermark)
+	if err != nil {
+		syncer.metrics.countDBError()
+		syncer.log.Error(err, "failed to get changed status keys", "since", syncer.watermark)
+		return
+	}
+
+	if len(keys) == 0 {
+		return
+	}
+
+	syncer.sync(ctx, keys)
+	syncer.watermark = watermark
+}
+
+// sync syncs the objects with the given keys, or all the objects if keys is nil, and waits for the handlers it
+// submitted, bounded by the sync interval unless the syncer syncs once.
+func (syncer *genericDBSyncer) sync(ctx context.Context, keys []string) {
+	syncCtx := ctx
+
+	if syncer.summary == nil { // a sync once must not give up on the objects it did not sync in time
+		var cancelFunc context.CancelFunc
+
+		syncCtx, cancelFunc = context.WithTimeout(ctx, syncer.currentSyncInterval())
+		defer cancelFunc()
+	}
+
+	start := time.Now()
+	errorsBefore := syncer.metrics.errorCount()
+
+	if err := syncer.syncObjects(syncCtx, keys); err != nil && !errors.Is(err, errSyncerWorkersStopped) {
+		syncer.log.Error(err, "failed to sync")
+	}
+
+	syncer.workers.wait()
+
+	syncer.metrics.observeSync(start, errorsBefore)
+}
+
+// syncObjects lists the spec objects and fetches their statuses by pages of up to specObjectsPageSize objects, then
+// submits a handler per object that has a status to apply.
+func (syncer *genericDBSyncer) syncObjects(ctx context.Context, keys []string) error {
+	syncer.log.Info("performing sync", "pendingJobs", syncer.workers.pendingJobs(),
+		"poolQueueDepth", syncer.workers.queueDepth())
+
+	objects, err := syncer.dbSyncer.ListSpecObjects(ctx, syncer.store, keys)
+	if err != nil {
+		syncer.metrics.countDBError()
+		return fmt.Errorf("failed to list spec objects - %w", err)
+	}
+
+	syncer.metrics.countRowsScanned(len(objects))
+
+	staleLeafHubs := syncer.queryStaleLeafHubs(ctx)
+
+	for start := 0; start < len(objects); start += specObjectsPageSize {
+		end := start + specObjectsPageSize
+		if end > len(objects) {
+			end = len(objects)
+		}
+
+		if err := syncer.syncObjectsPage(ctx, objects[start:end], staleLeafHubs); err != nil {
+			if syncer.summary != nil {
+				syncer.summary.countNotHandled(len(objects) - end)
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// queryStaleLeafHubs returns the stale leaf hubs, none if staleness is ignored or cannot be read.
+func (syncer *genericDBSyncer) queryStaleLeafHubs(ctx context.Context) map[string]struct{} {
+	if syncer.staleLeafHubThreshold == 0 {
+		return nil
+	}
+
+	staleLeafHubs, err := syncer.store.StaleLeafHubs(ctx, syncer.staleLeafHubThreshold)
+	if err != nil {
+		syncer.metrics.countDBError()
+		syncer.log.Error(err, "failed to get stale leaf hubs, all the leaf hubs are considered up to date")
+
+		return nil
+	}
+
+	return staleLeafHubs
+}
+
+func (syncer *genericDBSyncer) syncObjectsPage(ctx context.Context, objects []*SpecObject,
+	staleLeafHubs map[string]struct{}) error {
+	leafHubStatuses, err := syncer.dbSyncer.FetchStatuses(ctx, syncer.store, objects)
+	if err != nil {
+		syncer.metrics.countDBError()
+		syncer.log.Error(err, "failed to fetch statuses")
+
+		for _, object := range objects {
+			syncer.events.warning(syncer.eventTarget(object), eventReasonDBReadFailed,
+				"failed to read status from the database: %v", err)
+		}
+
+		return nil // the next page may succeed
+	}
+
+	duplicateClustersStrategy, leafHubUpdateTimes := syncer.queryLeafHubUpdateTimes(ctx, objects)
+	options := &aggregationOptions{
+		staleLeafHubs:             staleLeafHubs,
+		staleLeafHubPolicy:        syncer.staleLeafHubPolicy,
+		duplicateClustersStrategy: duplicateClustersStrategy,
+		leafHubUpdateTimes:        leafHubUpdateTimes,
+		qualifyClusterNames:       syncer.qualifyClusterNames,
+	}
+
+	for i, object := range objects {
+		syncer.metrics.countObjectHandled()
+
+		aggregation := aggregateObjectStatus(syncer.dbSyncer, object,
+			syncer.skipInvalidStatuses(object, leafHubStatuses[object.Key]), options)
+
+		// a cluster is expected to be managed by a single leaf hub
+		if conflictingClusters := aggregation.conflictingClusters; len(conflictingClusters) > 0 {
+			syncer.metrics.countDuplicateClusters(len(conflictingClusters))
+			syncer.events.warning(syncer.eventTarget(object), eventReasonAggregationConflict,
+				"clusters reported by more than one leaf hub: %s", strings.Join(conflictingClusters, ", "))
+		}
+
+		// nothing to apply, e.g. no status rows were found. the stale leaf hubs are still annotated, e.g. when their
+		// statuses were dropped but the DBSyncer cannot clear them
+		if aggregation.status == nil && len(aggregation.staleLeafHubNames) == 0 {
+			continue
+		}
+
+		object := object
+
+		if err := syncer.workers.submit(ctx, func() {
+			syncer.handleObject(ctx, object, aggregation.status, aggregation.staleLeafHubNames)
+		}); err != nil {
+			if syncer.summary != nil {
+				syncer.summary.countSubmitFailure(len(objects) - i)
 			}
 
-			ctxWithTimeout, cancelFunc = context.WithTimeout(ctx, syncer.syncInterval)
+			return fmt.Errorf("failed to submit handler - %w", err)
+		}
+	}
+
+	return nil
+}
 
-			syncer.syncFunc(ctxWithTimeout)
+// skipInvalidStatuses returns the statuses that could be read, the others are logged and recorded as an Event on the
+// object.
+func (syncer *genericDBSyncer) skipInvalidStatuses(object *SpecObject,
+	leafHubStatuses []*LeafHubStatus) []*LeafHubStatus {
+	var validStatuses []*LeafHubStatus
+
+	for _, leafHubStatus := range leafHubStatuses {
+		if leafHubStatus.Err == nil {
+			validStatuses = append(validStatuses, leafHubStatus)
+			continue
 		}
+
+		syncer.metrics.countDBError()
+		syncer.log.Error(leafHubStatus.Err, "skipping invalid status", "name", object.Name,
+			"namespace", object.Namespace, "leafHub", leafHubStatus.LeafHubName)
+		syncer.events.warning(syncer.eventTarget(object), eventReasonInvalidStatus,
+			"skipped invalid status reported by leaf hub %s: %v", leafHubStatus.LeafHubName, leafHubStatus.Err)
 	}
+
+	return validStatuses
+}
+
+func (syncer *genericDBSyncer) handleObject(ctx context.Context, object *SpecObject, aggregatedStatus interface{},
+	staleLeafHubNames []string) {
+	syncer.log.V(1).Info("handling an object", "name", object.Name, "namespace", object.Namespace)
+
+	if aggregatedStatus != nil && !syncer.applyAggregatedStatus(ctx, object, aggregatedStatus) {
+		return
+	}
+
+	if syncer.staleLeafHubThreshold == 0 {
+		return
+	}
+
+	if err := updateStaleLeafHubsAnnotation(ctx, syncer.k8sReader, syncer.k8sClient, syncer.eventTarget(object),
+		staleLeafHubNames); err != nil {
+		syncer.metrics.countAPIError(err)
+		syncer.log.Error(err, "failed to annotate stale leaf hubs", "name", object.Name, "namespace", object.Namespace)
+	}
+}
+
+// applyAggregatedStatus applies the aggregated status of the object, returns whether it succeeded.
+func (syncer *genericDBSyncer) applyAggregatedStatus(ctx context.Context, object *SpecObject,
+	aggregatedStatus interface{}) bool {
+	patched, err := syncer.applyWithRetry(ctx, object, aggregatedStatus)
+
+	if syncer.summary != nil {
+		syncer.summary.countHandled(patched, err)
+	}
+
+	if err != nil {
+		syncer.metrics.countAPIError(err)
+		syncer.metrics.countWriteFailure(isTransientError(err))
+		syncer.events.warning(syncer.eventTarget(object), eventReasonUpdateFailed, "failed to update status: %v",
+			err)
+		syncer.log.Error(err, "failed to update status", "name", object.Name, "namespace", object.Namespace)
+
+		return false
+	}
+
+	syncer.metrics.countPatch(patched)
+
+	return true
+}
+
+func (syncer *genericDBSyncer) eventTarget(object *SpecObject) client.Object {
+	gvk := syncer.dbSyncer.SpecGroupVersionKind()
+
+	return eventTarget(gvk.GroupVersion().String(), gvk.Kind, object.Name, object.Namespace, object.UID)
 }
@@ -0,0 +1,107 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	databaseHealthCheckName  = "database"
+	dbSyncersHealthCheckName = "db-syncers"
+	databasePingTimeout      = 5 * time.Second
+)
+
+// DefaultHealthTickMultiple is the default of Config.HealthTickMultiple, used if it is not positive.
+const DefaultHealthTickMultiple = 3
+
+var errSyncersStalled = errors.New("DB syncers stalled")
+
+// dbSyncersHealth checks that the DB syncers make progress, i.e. complete a tick of their periodic sync within
+// tickMultiple times their sync interval.
+type dbSyncersHealth struct {
+	tickMultiple int
+	syncers      map[string]*genericDBSyncer
+}
+
+func newDBSyncersHealth(tickMultiple int) *dbSyncersHealth {
+	if tickMultiple <= 0 {
+		tickMultiple = DefaultHealthTickMultiple
+	}
+
+	return &dbSyncersHealth{
+		tickMultiple: tickMultiple,
+		syncers:      map[string]*genericDBSyncer{},
+	}
+}
+
+// register adds a DB syncer to the health checks. must be called before the manager is started.
+func (health *dbSyncersHealth) register(syncerName string, syncer *genericDBSyncer) {
+	health.syncers[syncerName] = syncer
+}
+
+// check returns an error listing the DB syncers that did not complete a tick in time. syncers that were not started
+// yet (e.g. while waiting for the leader election) are considered healthy.
+func (health *dbSyncersHealth) check(_ *http.Request) error {
+	var stalledSyncers []string
+
+	for syncerName, syncer := range health.syncers {
+		lastTick := syncer.lastTickTime()
+		if lastTick.IsZero() {
+			continue
+		}
+
+		if time.Since(lastTick) > time.Duration(health.tickMultiple)*syncer.currentSyncInterval() {
+			stalledSyncers = append(stalledSyncers, fmt.Sprintf("%s (last tick at %s)", syncerName,
+				lastTick.Format(time.RFC3339)))
+		}
+	}
+
+	if len(stalledSyncers) > 0 {
+		sort.Strings(stalledSyncers)
+		return fmt.Errorf("%w: %s", errSyncersStalled, strings.Join(stalledSyncers, ", "))
+	}
+
+	return nil
+}
+
+// databaseCheck returns a health check that pings the database.
+func databaseCheck(store Store) func(*http.Request) error {
+	return func(request *http.Request) error {
+		ctx, cancelFunc := context.WithTimeout(request.Context(), databasePingTimeout)
+		defer cancelFunc()
+
+		if err := store.Ping(ctx); err != nil {
+			return fmt.Errorf("failed to ping the database - %w", err)
+		}
+
+		return nil
+	}
+}
+
+// addHealthChecks adds the liveness and readiness checks of the DB syncers to the manager. a stalled syncer fails
+// both checks, an unreachable database fails only the readiness check since restarting does not fix it.
+func addHealthChecks(mgr ctrl.Manager, store Store, health *dbSyncersHealth) error {
+	if err := mgr.AddHealthzCheck(dbSyncersHealthCheckName, health.check); err != nil {
+		return fmt.Errorf("failed to add %s health check: %w", dbSyncersHealthCheckName, err)
+	}
+
+	if err := mgr.AddReadyzCheck(dbSyncersHealthCheckName, health.check); err != nil {
+		return fmt.Errorf("failed to add %s readiness check: %w", dbSyncersHealthCheckName, err)
+	}
+
+	if err := mgr.AddReadyzCheck(databaseHealthCheckName, databaseCheck(store)); err != nil {
+		return fmt.Errorf("failed to add %s readiness check: %w", databaseHealthCheckName, err)
+	}
+
+	return nil
+}
@@ -2,11 +2,33 @@ package dbsyncers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// specObjectsPageSize is the number of spec objects whose statuses are fetched by a single query.
+const specObjectsPageSize = 500
+
+// SQL expressions that evaluate to the key of a spec row, matching the payloads of the status notifications.
+const (
+	SpecRowIDKey             = "id::text"
+	SpecRowNamespacedNameKey = `(payload->'metadata'->>'namespace') || '/' || (payload->'metadata'->>'name')`
+)
+
+// SQL expressions that evaluate to the key of the spec row a status row belongs to.
+const (
+	StatusRowIDKey             = SpecRowIDKey
+	StatusRowNamespacedNameKey = SpecRowNamespacedNameKey
+	statusRowPlacementKey      = `(payload->'metadata'->>'namespace') || '/' ||
+		(payload->'metadata'->'labels'->>'cluster.open-cluster-management.io/placement')`
 )
 
 func createK8sResource(ctx context.Context, k8sClient client.Client, resource client.Object) error {
@@ -17,13 +39,124 @@ func createK8sResource(ctx context.Context, k8sClient client.Client, resource cl
 	// make sure resource version is empty - otherwise cannot create
 	resource.SetResourceVersion("")
 
+	// mark the resource as created by the status sync, so it can be garbage collected
+	labels := resource.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+
+	labels[statusSyncLabelKey] = statusSyncLabelValue
+	resource.SetLabels(labels)
+
+	desiredResource, ok := resource.DeepCopyObject().(client.Object)
+	if !ok {
+		return fmt.Errorf("failed to copy k8s-resource {name=%s, namespace=%s}", resource.GetName(),
+			resource.GetNamespace())
+	}
+
 	if err := k8sClient.Create(ctx, resource); err != nil {
 		return fmt.Errorf("failed to create k8s-resource - %w", err)
 	}
 
+	return patchDroppedStatus(ctx, k8sClient, resource, desiredResource)
+}
+
+// patchDroppedStatus patches the status of a created resource to its desired status, the API server drops the status
+// on create when the CRD of the resource has a status subresource.
+func patchDroppedStatus(ctx context.Context, k8sClient client.Client, createdResource client.Object,
+	desiredResource client.Object) error {
+	desiredContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(desiredResource)
+	if err != nil {
+		return fmt.Errorf("failed to convert k8s-resource - %w", err)
+	}
+
+	originalResource, ok := createdResource.DeepCopyObject().(client.Object)
+	if !ok {
+		return fmt.Errorf("failed to copy k8s-resource {name=%s, namespace=%s}", createdResource.GetName(),
+			createdResource.GetNamespace())
+	}
+
+	createdContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(createdResource)
+	if err != nil {
+		return fmt.Errorf("failed to convert k8s-resource - %w", err)
+	}
+
+	desiredStatus, found := desiredContent["status"]
+	if !found || equality.Semantic.DeepEqual(desiredStatus, createdContent["status"]) {
+		return nil
+	}
+
+	createdContent["status"] = desiredStatus
+
+	if unstructuredResource, ok := createdResource.(runtime.Unstructured); ok {
+		unstructuredResource.SetUnstructuredContent(createdContent)
+	} else if err := runtime.DefaultUnstructuredConverter.FromUnstructured(createdContent,
+		createdResource); err != nil {
+		return fmt.Errorf("failed to convert k8s-resource - %w", err)
+	}
+
+	if err := k8sClient.Status().Patch(ctx, createdResource, client.MergeFrom(originalResource)); err != nil {
+		return fmt.Errorf("failed to patch status of created k8s-resource - %w", err)
+	}
+
 	return nil
 }
 
+// deleteK8sResource deletes a resource, a resource that is already deleted is ignored.
+func deleteK8sResource(ctx context.Context, k8sClient client.Client, resource client.Object) error {
+	if err := k8sClient.Delete(ctx, resource); err != nil && !errors.IsNotFound(err) {
+		return newAPIError(operationDelete, fmt.Errorf("failed to delete k8s-resource {name=%s, namespace=%s} - %w",
+			resource.GetName(), resource.GetNamespace(), err))
+	}
+
+	return nil
+}
+
+// labelStatusSynced labels a spec object whose status is written by a DB syncer with statusSyncLabelKey, so that the
+// garbage collection only clears the statuses written by the DB syncers. it is labeled before its status is written,
+// by a patch of its metadata only.
+func labelStatusSynced(ctx context.Context, k8sClient client.Client, resource client.Object) error {
+	if resource.GetLabels()[statusSyncLabelKey] == statusSyncLabelValue {
+		return nil
+	}
+
+	gvk, err := apiutil.GVKForObject(resource, k8sClient.Scheme())
+	if err != nil {
+		return fmt.Errorf("failed to get kind of k8s-resource {name=%s, namespace=%s} - %w", resource.GetName(),
+			resource.GetNamespace(), err)
+	}
+
+	labeledResource := &v1.PartialObjectMetadata{}
+	labeledResource.SetGroupVersionKind(gvk)
+	labeledResource.SetName(resource.GetName())
+	labeledResource.SetNamespace(resource.GetNamespace())
+	labeledResource.SetLabels(map[string]string{statusSyncLabelKey: statusSyncLabelValue})
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": labeledResource.GetLabels()},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal status sync label patch - %w", err)
+	}
+
+	err = k8sClient.Patch(ctx, labeledResource, client.RawPatch(types.MergePatchType, patch))
+	if err != nil && !errors.IsNotFound(err) {
+		return newAPIError(operationPatch, fmt.Errorf("failed to label k8s-resource {name=%s, namespace=%s} - %w",
+			resource.GetName(), resource.GetNamespace(), err))
+	}
+
+	return nil
+}
+
+// controllerUID returns the UID of the controller owner of a resource, empty if it has none.
+func controllerUID(resource client.Object) string {
+	if ownerReference := v1.GetControllerOf(resource); ownerReference != nil {
+		return string(ownerReference.UID)
+	}
+
+	return ""
+}
+
 func setOwnerReference(resource client.Object, ownerReference *v1.OwnerReference) {
 	resource.SetOwnerReferences([]v1.OwnerReference{*ownerReference})
 }
@@ -43,3 +176,19 @@ func newTrue() *bool {
 	t := true
 	return &t
 }
+
+// specObjectKeys returns the keys of the spec objects.
+func specObjectKeys(objects []*SpecObject) []string {
+	keys := make([]string, 0, len(objects))
+
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+
+	return keys
+}
+
+// namespacedNameKey returns the key of an object as evaluated by SpecRowNamespacedNameKey.
+func namespacedNameKey(namespace string, name string) string {
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
@@ -0,0 +1,364 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	clustersv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+)
+
+var errUnknownKeyExpression = errors.New("unknown key expression")
+
+// MemorySpecRow is a row of a spec table of a MemoryStore.
+type MemorySpecRow struct {
+	ID      string
+	Payload json.RawMessage
+	Deleted bool
+}
+
+// MemoryStatusRow is a row of a status table of a MemoryStore. ID is the id of the policy in the compliance status
+// table, ClusterName and Compliance are only set in the compliance status table.
+type MemoryStatusRow struct {
+	ID          string
+	LeafHubName string
+	Payload     json.RawMessage
+	ClusterName string
+	Compliance  string
+	// UpdatedAt is set to the current time when the row is added, if zero.
+	UpdatedAt time.Time
+}
+
+// MemoryStore is a Store that holds the spec and status tables in memory, to run the DB syncers without PostgreSQL,
+// e.g. in tests. the key expressions are evaluated in Go, only those of this package are supported.
+type MemoryStore struct {
+	lock         sync.RWMutex
+	specTables   map[string][]*MemorySpecRow
+	statusTables map[string][]*MemoryStatusRow
+	heartbeats   map[string]time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		specTables:   map[string][]*MemorySpecRow{},
+		statusTables: map[string][]*MemoryStatusRow{},
+		heartbeats:   map[string]time.Time{},
+	}
+}
+
+// AddSpecRow adds a row to a spec table.
+func (store *MemoryStore) AddSpecRow(tableName string, row *MemorySpecRow) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.specTables[tableName] = append(store.specTables[tableName], row)
+}
+
+// AddStatusRow adds a row to a status table.
+func (store *MemoryStore) AddStatusRow(tableName string, row *MemoryStatusRow) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if row.UpdatedAt.IsZero() {
+		row.UpdatedAt = time.Now()
+	}
+
+	store.statusTables[tableName] = append(store.statusTables[tableName], row)
+}
+
+// SetLeafHubHeartbeat sets the time of the last heartbeat of a leaf hub.
+func (store *MemoryStore) SetLeafHubHeartbeat(leafHubName string, lastTimestamp time.Time) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.heartbeats[leafHubName] = lastTimestamp
+}
+
+// SpecObjects returns the non-deleted objects of a spec table, keyed by keyExpression.
+func (store *MemoryStore) SpecObjects(_ context.Context, tableName string, keyExpression string,
+	keys []string) ([]*SpecObject, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	selectedKeys := keySet(keys)
+
+	var objects []*SpecObject
+
+	for _, row := range store.specTables[tableName] {
+		if row.Deleted {
+			continue
+		}
+
+		key, found, err := evaluateKeyExpression(keyExpression, row.ID, row.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate key of spec.%s row %s - %w", tableName, row.ID, err)
+		}
+
+		if !found || (keys != nil && !selectedKeys[key]) {
+			continue
+		}
+
+		metadata, err := payloadMetadata(row.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode spec.%s row %s - %w", tableName, row.ID, err)
+		}
+
+		objects = append(objects, &SpecObject{
+			Key:       key,
+			UID:       row.ID,
+			Name:      stringValue(metadata.Name),
+			Namespace: stringValue(metadata.Namespace),
+		})
+	}
+
+	return objects, nil
+}
+
+// StatusRows returns the rows of a status table whose keyExpression evaluates to one of the keys.
+func (store *MemoryStore) StatusRows(_ context.Context, tableName string, keyExpression string,
+	columns StatusColumns, keys []string) ([]*StatusRow, error) {
+	if columns != StatusColumnsPayload && columns != StatusColumnsCompliance {
+		return nil, fmt.Errorf("%w: %q", errUnknownStatusColumns, columns)
+	}
+
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	selectedKeys := keySet(keys)
+
+	var statusRows []*StatusRow
+
+	err := store.forEachStatusRow(tableName, keyExpression, func(key string, row *MemoryStatusRow) {
+		if !selectedKeys[key] {
+			return
+		}
+
+		statusRow := &StatusRow{Key: key, LeafHubName: row.LeafHubName}
+
+		if columns == StatusColumnsPayload {
+			statusRow.Payload = row.Payload
+		} else {
+			statusRow.ClusterName, statusRow.Compliance = row.ClusterName, row.Compliance
+		}
+
+		statusRows = append(statusRows, statusRow)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(statusRows, func(i, j int) bool {
+		if statusRows[i].Key != statusRows[j].Key {
+			return statusRows[i].Key < statusRows[j].Key
+		}
+
+		if statusRows[i].LeafHubName != statusRows[j].LeafHubName {
+			return statusRows[i].LeafHubName < statusRows[j].LeafHubName
+		}
+
+		return statusRows[i].ClusterName < statusRows[j].ClusterName
+	})
+
+	return statusRows, nil
+}
+
+// ChangedStatusKeys returns the keys of the objects whose status rows were updated after since, minus
+// statusChangesOverlap, and the latest update time of these rows.
+func (store *MemoryStore) ChangedStatusKeys(_ context.Context, tableName string, keyExpression string,
+	since time.Time) ([]string, time.Time, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	changedKeys := map[string]struct{}{}
+	watermark := since
+
+	err := store.forEachStatusRow(tableName, keyExpression, func(key string, row *MemoryStatusRow) {
+		if !row.UpdatedAt.After(since.Add(-statusChangesOverlap)) {
+			return
+		}
+
+		changedKeys[key] = struct{}{}
+
+		if row.UpdatedAt.After(watermark) {
+			watermark = row.UpdatedAt
+		}
+	})
+	if err != nil {
+		return nil, since, err
+	}
+
+	keys := make([]string, 0, len(changedKeys))
+	for key := range changedKeys {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys, watermark, nil
+}
+
+// StatusWatermark returns the latest update time of the rows of a status table.
+func (store *MemoryStore) StatusWatermark(_ context.Context, tableName string) (time.Time, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	watermark := time.Unix(0, 0)
+
+	for _, row := range store.statusTables[tableName] {
+		if row.UpdatedAt.After(watermark) {
+			watermark = row.UpdatedAt
+		}
+	}
+
+	return watermark, nil
+}
+
+// LeafHubUpdateTimes returns the last update time of the status rows of each leaf hub, by the key of the spec object
+// they belong to.
+func (store *MemoryStore) LeafHubUpdateTimes(_ context.Context, tableName string, keyExpression string,
+	keys []string) (map[string]map[string]time.Time, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	selectedKeys := keySet(keys)
+	leafHubUpdateTimes := map[string]map[string]time.Time{}
+
+	err := store.forEachStatusRow(tableName, keyExpression, func(key string, row *MemoryStatusRow) {
+		if !selectedKeys[key] {
+			return
+		}
+
+		if _, found := leafHubUpdateTimes[key]; !found {
+			leafHubUpdateTimes[key] = map[string]time.Time{}
+		}
+
+		if row.UpdatedAt.After(leafHubUpdateTimes[key][row.LeafHubName]) {
+			leafHubUpdateTimes[key][row.LeafHubName] = row.UpdatedAt
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return leafHubUpdateTimes, nil
+}
+
+// StaleLeafHubs returns the names of the leaf hubs whose last heartbeat is older than the threshold.
+func (store *MemoryStore) StaleLeafHubs(_ context.Context, threshold time.Duration) (map[string]struct{}, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	staleLeafHubs := map[string]struct{}{}
+
+	for leafHubName, lastTimestamp := range store.heartbeats {
+		if time.Since(lastTimestamp) > threshold {
+			staleLeafHubs[leafHubName] = struct{}{}
+		}
+	}
+
+	return staleLeafHubs, nil
+}
+
+// Ping always succeeds.
+func (store *MemoryStore) Ping(context.Context) error {
+	return nil
+}
+
+// forEachStatusRow calls handle with the rows of a status table whose key is not null, must be called with the lock
+// held.
+func (store *MemoryStore) forEachStatusRow(tableName string, keyExpression string,
+	handle func(key string, row *MemoryStatusRow)) error {
+	for _, row := range store.statusTables[tableName] {
+		key, found, err := evaluateKeyExpression(keyExpression, row.ID, row.Payload)
+		if err != nil {
+			return fmt.Errorf("failed to evaluate key of status.%s row - %w", tableName, err)
+		}
+
+		if found {
+			handle(key, row)
+		}
+	}
+
+	return nil
+}
+
+// memoryRowMetadata is the metadata of the payload of a row, the fields are nil if missing as in SQL.
+type memoryRowMetadata struct {
+	Name      *string           `json:"name"`
+	Namespace *string           `json:"namespace"`
+	Labels    map[string]string `json:"labels"`
+}
+
+func payloadMetadata(payload json.RawMessage) (*memoryRowMetadata, error) {
+	var object struct {
+		Metadata memoryRowMetadata `json:"metadata"`
+	}
+
+	if len(payload) == 0 {
+		return &object.Metadata, nil
+	}
+
+	if err := json.Unmarshal(payload, &object); err != nil {
+		return nil, fmt.Errorf("failed to decode payload - %w", err)
+	}
+
+	return &object.Metadata, nil
+}
+
+// evaluateKeyExpression evaluates one of the key expressions of this package on a row, returns false if the key is
+// null in SQL.
+func evaluateKeyExpression(keyExpression string, id string, payload json.RawMessage) (string, bool, error) {
+	if keyExpression == SpecRowIDKey {
+		return id, id != "", nil
+	}
+
+	metadata, err := payloadMetadata(payload)
+	if err != nil {
+		return "", false, err
+	}
+
+	var name *string
+
+	switch keyExpression {
+	case SpecRowNamespacedNameKey:
+		name = metadata.Name
+	case statusRowPlacementKey:
+		if placementName, found := metadata.Labels[clustersv1beta1.PlacementLabel]; found {
+			name = &placementName
+		}
+	default:
+		return "", false, fmt.Errorf("%w: %s", errUnknownKeyExpression, keyExpression)
+	}
+
+	if metadata.Namespace == nil || name == nil {
+		return "", false, nil
+	}
+
+	return namespacedNameKey(*metadata.Namespace, *name), true, nil
+}
+
+func keySet(keys []string) map[string]bool {
+	set := make(map[string]bool, len(keys))
+
+	for _, key := range keys {
+		set[key] = true
+	}
+
+	return set
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+
+	return *value
+}
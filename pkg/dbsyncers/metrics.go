@@ -0,0 +1,300 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "hub_of_hubs_status_sync"
+	syncerLabel      = "syncer"
+	resultLabel      = "result"
+	operationLabel   = "operation"
+	dryRunLabel      = "dry_run"
+	errorLabel       = "error"
+	resultApplied    = "applied"
+	resultSkipped    = "skipped"
+	operationGet     = "get"
+	operationCreate  = "create"
+	operationPatch   = "patch"
+	operationList    = "list"
+	operationDelete  = "delete"
+	operationUnknown = "unknown"
+	operationQuery   = "query"
+	errorTransient   = "transient"
+	errorPermanent   = "permanent"
+
+	// sync duration buckets from 10ms to ~80s.
+	syncDurationBucketsStart  = 0.01
+	syncDurationBucketsFactor = 2
+	syncDurationBucketsCount  = 14
+)
+
+// apiError is an error of a k8s API operation, the operation is used to label the API errors metric.
+type apiError struct {
+	operation string
+	err       error
+}
+
+func newAPIError(operation string, err error) *apiError {
+	return &apiError{operation: operation, err: err}
+}
+
+func (apiErr *apiError) Error() string {
+	return apiErr.err.Error()
+}
+
+func (apiErr *apiError) Unwrap() error {
+	return apiErr.err
+}
+
+// dbSyncersMetrics holds the metrics of all the DB syncers, labeled by the syncer name.
+type dbSyncersMetrics struct {
+	syncDuration       *prometheus.HistogramVec
+	lastSuccessfulSync *prometheus.GaugeVec
+	rowsScanned        *prometheus.CounterVec
+	objectsHandled     *prometheus.CounterVec
+	dbErrors           *prometheus.CounterVec
+	apiErrors          *prometheus.CounterVec
+	patches            *prometheus.CounterVec
+	abandonedHandlers  *prometheus.CounterVec
+	garbageCollected   *prometheus.CounterVec
+	gcErrors           *prometheus.CounterVec
+	duplicateClusters  *prometheus.CounterVec
+	writeRetries       *prometheus.CounterVec
+	writeFailures      *prometheus.CounterVec
+}
+
+func newDBSyncersMetrics(registerer prometheus.Registerer, workerPool *workerPool) (*dbSyncersMetrics, error) {
+	dbSyncersMetrics := &dbSyncersMetrics{
+		syncDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "sync_duration_seconds",
+			Help:      "Duration of a sync, including the handling of the synced objects.",
+			Buckets: prometheus.ExponentialBuckets(syncDurationBucketsStart, syncDurationBucketsFactor,
+				syncDurationBucketsCount),
+		}, []string{syncerLabel}),
+		lastSuccessfulSync: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "last_successful_sync_timestamp_seconds",
+			Help:      "Unix time of the end of the last sync that completed without errors.",
+		}, []string{syncerLabel}),
+		rowsScanned: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rows_scanned_total",
+			Help:      "Number of spec rows scanned.",
+		}, []string{syncerLabel}),
+		objectsHandled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "objects_handled_total",
+			Help:      "Number of objects whose status was aggregated.",
+		}, []string{syncerLabel}),
+		dbErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "db_errors_total",
+			Help:      "Number of failed DB queries.",
+		}, []string{syncerLabel}),
+		apiErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "api_errors_total",
+			Help:      "Number of failed k8s API calls, by operation.",
+		}, []string{syncerLabel, operationLabel}),
+		patches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "patches_total",
+			Help:      "Number of status writes, by whether they were applied or skipped since nothing changed.",
+		}, []string{syncerLabel, resultLabel}),
+		abandonedHandlers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "abandoned_handlers_total",
+			Help:      "Number of handlers that were still pending when the shutdown grace period passed.",
+		}, []string{syncerLabel}),
+		garbageCollected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "garbage_collected_total",
+			Help:      "Number of CRs deleted or cleared since their spec objects were deleted, or that would be in dry-run.",
+		}, []string{syncerLabel, dryRunLabel}),
+		gcErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "garbage_collection_errors_total",
+			Help:      "Number of failed DB queries and k8s API calls of the garbage collection, by operation.",
+		}, []string{syncerLabel, operationLabel}),
+		duplicateClusters: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "duplicate_clusters_total",
+			Help:      "Number of clusters reported by more than one leaf hub, counted on every sync of their object.",
+		}, []string{syncerLabel}),
+		writeRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "write_retries_total",
+			Help:      "Number of retries of status writes that failed with a transient error.",
+		}, []string{syncerLabel}),
+		writeFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "write_failures_total",
+			Help:      "Number of status writes that failed after their retries, by whether the error was transient.",
+		}, []string{syncerLabel, errorLabel}),
+	}
+
+	collectors := []prometheus.Collector{
+		dbSyncersMetrics.syncDuration,
+		dbSyncersMetrics.lastSuccessfulSync,
+		dbSyncersMetrics.rowsScanned,
+		dbSyncersMetrics.objectsHandled,
+		dbSyncersMetrics.dbErrors,
+		dbSyncersMetrics.apiErrors,
+		dbSyncersMetrics.patches,
+		dbSyncersMetrics.abandonedHandlers,
+		dbSyncersMetrics.garbageCollected,
+		dbSyncersMetrics.gcErrors,
+		dbSyncersMetrics.duplicateClusters,
+		dbSyncersMetrics.writeRetries,
+		dbSyncersMetrics.writeFailures,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "worker_pool_queue_depth",
+			Help:      "Number of jobs waiting for a free worker in the shared worker pool.",
+		}, func() float64 { return float64(workerPool.queueDepth()) }),
+	}
+
+	for _, collector := range collectors {
+		if err := registerer.Register(collector); err != nil {
+			return nil, fmt.Errorf("failed to register metric: %w", err)
+		}
+	}
+
+	return dbSyncersMetrics, nil
+}
+
+// forSyncer returns the metrics of a single DB syncer.
+func (dbSyncersMetrics *dbSyncersMetrics) forSyncer(syncerName string) *syncerMetrics {
+	return &syncerMetrics{
+		syncDuration:       dbSyncersMetrics.syncDuration.WithLabelValues(syncerName),
+		lastSuccessfulSync: dbSyncersMetrics.lastSuccessfulSync.WithLabelValues(syncerName),
+		rowsScanned:        dbSyncersMetrics.rowsScanned.WithLabelValues(syncerName),
+		objectsHandled:     dbSyncersMetrics.objectsHandled.WithLabelValues(syncerName),
+		dbErrors:           dbSyncersMetrics.dbErrors.WithLabelValues(syncerName),
+		apiErrors:          dbSyncersMetrics.apiErrors.MustCurryWith(prometheus.Labels{syncerLabel: syncerName}),
+		patchesApplied:     dbSyncersMetrics.patches.WithLabelValues(syncerName, resultApplied),
+		patchesSkipped:     dbSyncersMetrics.patches.WithLabelValues(syncerName, resultSkipped),
+		abandonedHandlers:  dbSyncersMetrics.abandonedHandlers.WithLabelValues(syncerName),
+		garbageCollected:   dbSyncersMetrics.garbageCollected.MustCurryWith(prometheus.Labels{syncerLabel: syncerName}),
+		gcErrors:           dbSyncersMetrics.gcErrors.MustCurryWith(prometheus.Labels{syncerLabel: syncerName}),
+		duplicateClusters:  dbSyncersMetrics.duplicateClusters.WithLabelValues(syncerName),
+		writeRetries:       dbSyncersMetrics.writeRetries.WithLabelValues(syncerName),
+		transientFailures:  dbSyncersMetrics.writeFailures.WithLabelValues(syncerName, errorTransient),
+		permanentFailures:  dbSyncersMetrics.writeFailures.WithLabelValues(syncerName, errorPermanent),
+	}
+}
+
+// syncerMetrics holds the metrics of a single DB syncer.
+type syncerMetrics struct {
+	syncDuration       prometheus.Observer
+	lastSuccessfulSync prometheus.Gauge
+	rowsScanned        prometheus.Counter
+	objectsHandled     prometheus.Counter
+	dbErrors           prometheus.Counter
+	apiErrors          *prometheus.CounterVec
+	patchesApplied     prometheus.Counter
+	patchesSkipped     prometheus.Counter
+	abandonedHandlers  prometheus.Counter
+	garbageCollected   *prometheus.CounterVec
+	gcErrors           *prometheus.CounterVec
+	duplicateClusters  prometheus.Counter
+	writeRetries       prometheus.Counter
+	transientFailures  prometheus.Counter
+	permanentFailures  prometheus.Counter
+	// errors counts all the errors, to tell whether a sync completed without errors.
+	errors int64
+}
+
+// observeSync records the duration of a sync, and its end time if no errors were counted since errorsBefore.
+func (syncerMetrics *syncerMetrics) observeSync(start time.Time, errorsBefore int64) {
+	syncerMetrics.syncDuration.Observe(time.Since(start).Seconds())
+
+	if syncerMetrics.errorCount() == errorsBefore {
+		syncerMetrics.lastSuccessfulSync.SetToCurrentTime()
+	}
+}
+
+// errorCount returns the number of errors counted so far.
+func (syncerMetrics *syncerMetrics) errorCount() int64 {
+	return atomic.LoadInt64(&syncerMetrics.errors)
+}
+
+func (syncerMetrics *syncerMetrics) countRowsScanned(count int) {
+	syncerMetrics.rowsScanned.Add(float64(count))
+}
+
+func (syncerMetrics *syncerMetrics) countObjectHandled() {
+	syncerMetrics.objectsHandled.Inc()
+}
+
+func (syncerMetrics *syncerMetrics) countDBError() {
+	atomic.AddInt64(&syncerMetrics.errors, 1)
+	syncerMetrics.dbErrors.Inc()
+}
+
+// countAPIError counts a failed k8s API call, by the operation of the apiError in err's chain.
+func (syncerMetrics *syncerMetrics) countAPIError(err error) {
+	atomic.AddInt64(&syncerMetrics.errors, 1)
+	syncerMetrics.apiErrors.WithLabelValues(apiOperation(err)).Inc()
+}
+
+// apiOperation returns the operation of the apiError in err's chain, unknown if there is none.
+func apiOperation(err error) string {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr.operation
+	}
+
+	return operationUnknown
+}
+
+// countPatch counts a status write that was either applied or skipped.
+func (syncerMetrics *syncerMetrics) countPatch(applied bool) {
+	if applied {
+		syncerMetrics.patchesApplied.Inc()
+	} else {
+		syncerMetrics.patchesSkipped.Inc()
+	}
+}
+
+func (syncerMetrics *syncerMetrics) countAbandonedHandlers(abandonedHandlers int64) {
+	syncerMetrics.abandonedHandlers.Add(float64(abandonedHandlers))
+}
+
+func (syncerMetrics *syncerMetrics) countGarbageCollected(dryRun bool) {
+	syncerMetrics.garbageCollected.WithLabelValues(strconv.FormatBool(dryRun)).Inc()
+}
+
+// countGarbageCollectionError counts a failed k8s API call of the garbage collection by its operation, or a failed DB
+// query as operationQuery. they are counted apart from the errors of the syncs, which they do not fail.
+func (syncerMetrics *syncerMetrics) countGarbageCollectionError(operation string) {
+	syncerMetrics.gcErrors.WithLabelValues(operation).Inc()
+}
+
+func (syncerMetrics *syncerMetrics) countDuplicateClusters(duplicateClusters int) {
+	syncerMetrics.duplicateClusters.Add(float64(duplicateClusters))
+}
+
+func (syncerMetrics *syncerMetrics) countWriteRetry() {
+	syncerMetrics.writeRetries.Inc()
+}
+
+// countWriteFailure counts a status write that failed after its retries, by whether the error was transient.
+func (syncerMetrics *syncerMetrics) countWriteFailure(transient bool) {
+	if transient {
+		syncerMetrics.transientFailures.Inc()
+	} else {
+		syncerMetrics.permanentFailures.Inc()
+	}
+}
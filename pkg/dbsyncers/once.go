@@ -0,0 +1,141 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// SyncSummary is the outcome of a single sync of all the objects of a DB syncer.
+type SyncSummary struct {
+	Syncer string
+	// Synced is the number of objects whose status was written.
+	Synced int64
+	// Skipped is the number of objects whose status did not change.
+	Skipped int64
+	// Failed is the number of objects whose status could not be written.
+	Failed int64
+	// Errors is the number of DB and k8s API errors, including those that failed the whole sync.
+	Errors int64
+}
+
+// Succeeded returns whether the sync completed without errors.
+func (summary *SyncSummary) Succeeded() bool {
+	return summary.Failed == 0 && summary.Errors == 0
+}
+
+func (summary *SyncSummary) countHandled(patched bool, err error) {
+	switch {
+	case err != nil:
+		atomic.AddInt64(&summary.Failed, 1)
+	case patched:
+		atomic.AddInt64(&summary.Synced, 1)
+	default:
+		atomic.AddInt64(&summary.Skipped, 1)
+	}
+}
+
+// countNotHandled counts the objects that were not handled because the sync failed as failed.
+func (summary *SyncSummary) countNotHandled(objects int) {
+	atomic.AddInt64(&summary.Failed, int64(objects))
+}
+
+// countSubmitFailure counts a handler that could not be submitted as an error, and the objects of the page that were
+// not submitted as failed.
+func (summary *SyncSummary) countSubmitFailure(unsubmittedObjects int) {
+	atomic.AddInt64(&summary.Errors, 1)
+	summary.countNotHandled(unsubmittedObjects)
+}
+
+// OnceReport collects the summaries of the DB syncers when they sync once instead of periodically.
+type OnceReport struct {
+	lock      sync.Mutex
+	expected  int
+	summaries []*SyncSummary
+	done      chan struct{}
+}
+
+// NewOnceReport returns an empty OnceReport.
+func NewOnceReport() *OnceReport {
+	return &OnceReport{done: make(chan struct{})}
+}
+
+// Done returns a channel that is closed once all the DB syncers reported their summaries.
+func (report *OnceReport) Done() <-chan struct{} {
+	return report.done
+}
+
+// Summaries returns the summaries reported so far, sorted by syncer name.
+func (report *OnceReport) Summaries() []*SyncSummary {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+
+	summaries := append([]*SyncSummary{}, report.summaries...)
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Syncer < summaries[j].Syncer })
+
+	return summaries
+}
+
+// Succeeded returns whether all the DB syncers reported a sync that completed without errors.
+func (report *OnceReport) Succeeded() bool {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+
+	if len(report.summaries) < report.expected {
+		return false
+	}
+
+	for _, summary := range report.summaries {
+		if !summary.Succeeded() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// expect sets the number of DB syncers that report a summary.
+func (report *OnceReport) expect(syncers int) {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+
+	report.expected = syncers
+	report.closeIfDone()
+}
+
+func (report *OnceReport) add(summary *SyncSummary) {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+
+	report.summaries = append(report.summaries, summary)
+	report.closeIfDone()
+}
+
+func (report *OnceReport) closeIfDone() {
+	select {
+	case <-report.done:
+	default:
+		if len(report.summaries) >= report.expected {
+			close(report.done)
+		}
+	}
+}
+
+// syncOnce syncs all the objects, waits for their handlers and reports the summary.
+func (syncer *genericDBSyncer) syncOnce(ctx context.Context) error {
+	defer syncer.workers.close()
+
+	errorsBefore := syncer.metrics.errorCount()
+
+	syncer.fullSync(ctx)
+	syncer.recordTick()
+
+	atomic.AddInt64(&syncer.summary.Errors, syncer.metrics.errorCount()-errorsBefore)
+	syncer.onceReport.add(syncer.summary)
+
+	return nil
+}
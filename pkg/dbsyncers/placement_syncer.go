@@ -6,114 +6,130 @@ package dbsyncers
 import (
 	"context"
 	"fmt"
-	"time"
 
-	"github.com/go-logr/logr"
-	"github.com/jackc/pgx/v4/pgxpool"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	clustersv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func addPlacementStatusDBSyncer(mgr ctrl.Manager, databaseConnectionPool *pgxpool.Pool,
-	syncInterval time.Duration) error {
-	err := mgr.Add(&genericDBSyncer{
-		syncInterval: syncInterval,
-		syncFunc: func(ctx context.Context) {
-			syncPlacements(ctx,
-				ctrl.Log.WithName("placement-db-syncer"),
-				databaseConnectionPool,
-				mgr.GetClient())
-		},
-	})
-	if err != nil {
-		return fmt.Errorf("failed to add placements syncer to the manager: %w", err)
-	}
+// placementDBSyncer syncs the number of selected clusters of the placements.
+type placementDBSyncer struct{}
+
+func newPlacementDBSyncer(ctrl.Manager) (DBSyncer, error) {
+	return &placementDBSyncer{}, nil
+}
 
-	return nil
+func (syncer *placementDBSyncer) SpecGroupVersionKind() schema.GroupVersionKind {
+	return schema.FromAPIVersionAndKind(clustersv1beta1APIGroup, placementKind)
 }
 
-func syncPlacements(ctx context.Context, log logr.Logger, databaseConnectionPool *pgxpool.Pool,
-	k8sClient client.Client) {
-	log.Info("performing sync of placement-status")
+func (syncer *placementDBSyncer) StatusTable() (string, string) {
+	return placementsStatusTableName, StatusRowNamespacedNameKey
+}
+
+func (syncer *placementDBSyncer) ListSpecObjects(ctx context.Context, specStore SpecStore,
+	keys []string) ([]*SpecObject, error) {
+	return QuerySpecObjects(ctx, specStore, placementsSpecTableName, SpecRowNamespacedNameKey, keys)
+}
 
-	rows, err := databaseConnectionPool.Query(ctx,
-		fmt.Sprintf(`SELECT payload->'metadata'->>'name', payload->'metadata'->>'namespace' 
-		FROM spec.%s WHERE deleted = FALSE`, placementsSpecTableName))
+func (syncer *placementDBSyncer) FetchStatuses(ctx context.Context, statusStore StatusStore,
+	objects []*SpecObject) (map[string][]*LeafHubStatus, error) {
+	leafHubPlacements, err := FetchStatusPayloads(ctx, statusStore, placementsStatusTableName,
+		StatusRowNamespacedNameKey, objects, func() interface{} { return &clustersv1beta1.Placement{} })
 	if err != nil {
-		log.Error(err, "error in getting placement spec")
-		return
+		return nil, fmt.Errorf("error in getting placements from DB - %w", err)
+	}
+
+	return leafHubPlacements, nil
+}
+
+// Aggregate returns the aggregated PlacementStatus, nil if no leaf hub reported the placement.
+func (syncer *placementDBSyncer) Aggregate(_ *SpecObject, leafHubStatuses []*LeafHubStatus) (interface{}, []string) {
+	if len(leafHubStatuses) == 0 { // no status resources found in DB - placement is never created here
+		return nil, nil
 	}
 
-	for rows.Next() {
-		var name, namespace string
+	placementStatus := &clustersv1beta1.PlacementStatus{}
 
-		err := rows.Scan(&name, &namespace)
-		if err != nil {
-			log.Error(err, "error in select", "table", placementsSpecTableName)
+	for _, leafHubStatus := range leafHubStatuses {
+		leafHubPlacement, ok := leafHubStatus.Status.(*clustersv1beta1.Placement)
+		if !ok {
 			continue
 		}
 
-		go handlePlacementStatus(ctx, log, databaseConnectionPool, k8sClient, name, namespace)
+		// the placement status does not name its clusters, a cluster selected on more than one leaf hub is counted
+		// once per leaf hub
+		placementStatus.NumberOfSelectedClusters += leafHubPlacement.Status.NumberOfSelectedClusters
 	}
-}
 
-func handlePlacementStatus(ctx context.Context, log logr.Logger, databaseConnectionPool *pgxpool.Pool,
-	k8sClient client.Client, placementName string, placementNamespace string) {
-	log.Info("handling a placement", "name", placementName, "namespace", placementNamespace)
+	return placementStatus, nil
+}
 
-	placementStatus, statusEntriesFound, err := getPlacementStatus(ctx, databaseConnectionPool,
-		placementName, placementNamespace)
-	if err != nil {
-		log.Error(err, "failed to get aggregated placement", "name", placementName, "namespace", placementNamespace)
-		return
+// ClearStale returns a copy of the Placement without selected clusters.
+func (syncer *placementDBSyncer) ClearStale(leafHubStatus interface{}) interface{} {
+	placement, ok := leafHubStatus.(*clustersv1beta1.Placement)
+	if !ok {
+		return leafHubStatus
 	}
 
-	if !statusEntriesFound { // no status resources found in DB - placement is never created here
-		return
-	}
+	clearedPlacement := placement.DeepCopy()
+	clearedPlacement.Status.NumberOfSelectedClusters = 0
 
-	if err := updatePlacementStatus(ctx, k8sClient, placementName, placementNamespace, placementStatus); err != nil {
-		log.Error(err, "failed to update placement status")
-	}
+	return clearedPlacement
 }
 
-// returns aggregated PlacementStatus and error.
-func getPlacementStatus(ctx context.Context, databaseConnectionPool *pgxpool.Pool,
-	placementName string, placementNamespace string) (*clustersv1beta1.PlacementStatus, bool, error) {
-	rows, err := databaseConnectionPool.Query(ctx,
-		fmt.Sprintf(`SELECT payload FROM status.%s
-			WHERE payload->'metadata'->>'name'=$1 AND payload->'metadata'->>'namespace'=$2`,
-			placementsStatusTableName), placementName, placementNamespace)
-	if err != nil {
-		return nil, false, fmt.Errorf("error in getting placements from DB - %w", err)
+func (syncer *placementDBSyncer) Apply(ctx context.Context, k8sClient client.Client, object *SpecObject,
+	aggregatedStatus interface{}) (bool, error) {
+	placementStatus, ok := aggregatedStatus.(*clustersv1beta1.PlacementStatus)
+	if !ok {
+		return false, fmt.Errorf("%w: %T", errUnexpectedStatusType, aggregatedStatus)
 	}
 
-	defer rows.Close()
+	return updatePlacementStatus(ctx, k8sClient, object.Name, object.Namespace, placementStatus)
+}
 
-	// build an aggregated placement
-	placementStatus := clustersv1beta1.PlacementStatus{}
-	statusEntriesFound := false
+// ListSyncedObjects returns the placements whose status was written by this syncer, by their namespaced name.
+func (syncer *placementDBSyncer) ListSyncedObjects(ctx context.Context, k8sReader client.Reader) ([]*SyncedObject,
+	error) {
+	placements := &clustersv1beta1.PlacementList{}
 
-	for rows.Next() {
-		var leafHubPlacement clustersv1beta1.Placement
+	if err := k8sReader.List(ctx, placements,
+		client.MatchingLabels{statusSyncLabelKey: statusSyncLabelValue}); err != nil {
+		return nil, newAPIError(operationList, fmt.Errorf("failed to list placements - %w", err))
+	}
 
-		if err := rows.Scan(&leafHubPlacement); err != nil {
-			return nil, false, fmt.Errorf("error getting placement from DB - %w", err)
-		}
+	var syncedObjects []*SyncedObject
 
-		statusEntriesFound = true
+	for i := range placements.Items {
+		placement := &placements.Items[i]
 
-		// assuming that cluster names are unique across the hubs, all we need to do is a complete merge
-		placementStatus.NumberOfSelectedClusters += leafHubPlacement.Status.NumberOfSelectedClusters
+		if placement.Status.NumberOfSelectedClusters == 0 { // nothing to clear
+			continue
+		}
+
+		syncedObjects = append(syncedObjects, &SyncedObject{
+			Key:    namespacedNameKey(placement.Namespace, placement.Name),
+			Object: placement,
+		})
 	}
 
-	return &placementStatus, statusEntriesFound, nil
+	return syncedObjects, nil
+}
+
+// Collect clears the number of selected clusters of the placement.
+func (syncer *placementDBSyncer) Collect(ctx context.Context, k8sClient client.Client, object client.Object) error {
+	_, err := updatePlacementStatus(ctx, k8sClient, object.GetName(), object.GetNamespace(),
+		&clustersv1beta1.PlacementStatus{})
+
+	return err
 }
 
+// returns whether the placement status was patched, it is not if the status did not change.
 func updatePlacementStatus(ctx context.Context, k8sClient client.Client,
-	placementName string, placementNamespace string, placementStatus *clustersv1beta1.PlacementStatus) error {
+	placementName string, placementNamespace string, placementStatus *clustersv1beta1.PlacementStatus) (bool, error) {
 	deployedPlacement := &clustersv1beta1.Placement{}
 
 	err := k8sClient.Get(ctx, client.ObjectKey{
@@ -122,11 +138,15 @@ func updatePlacementStatus(ctx context.Context, k8sClient client.Client,
 	}, deployedPlacement)
 	if err != nil {
 		if errors.IsNotFound(err) { // CR getting deleted
-			return nil
+			return false, nil
 		}
 
-		return fmt.Errorf("failed to get placement {name=%s, namespace=%s} - %w",
-			placementName, placementNamespace, err)
+		return false, newAPIError(operationGet, fmt.Errorf("failed to get placement {name=%s, namespace=%s} - %w",
+			placementName, placementNamespace, err))
+	}
+
+	if err := labelStatusSynced(ctx, k8sClient, deployedPlacement); err != nil {
+		return false, err
 	}
 
 	// if object exists, clone and update
@@ -134,11 +154,15 @@ func updatePlacementStatus(ctx context.Context, k8sClient client.Client,
 
 	deployedPlacement.Status.NumberOfSelectedClusters = placementStatus.NumberOfSelectedClusters
 
+	if equality.Semantic.DeepEqual(originalPlacement.Status, deployedPlacement.Status) {
+		return false, nil
+	}
+
 	err = k8sClient.Status().Patch(ctx, deployedPlacement, client.MergeFrom(originalPlacement))
 	if err != nil && !errors.IsNotFound(err) {
-		return fmt.Errorf("failed to update placement CR (name=%s, namespace=%s): %w",
-			deployedPlacement.Name, deployedPlacement.Namespace, err)
+		return false, newAPIError(operationPatch, fmt.Errorf("failed to update placement CR (name=%s, namespace=%s): %w",
+			deployedPlacement.Name, deployedPlacement.Namespace, err))
 	}
 
-	return nil
+	return true, nil
 }
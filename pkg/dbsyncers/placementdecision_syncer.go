@@ -6,127 +6,202 @@ package dbsyncers
 import (
 	"context"
 	"fmt"
-	"time"
 
-	"github.com/go-logr/logr"
-	"github.com/jackc/pgx/v4/pgxpool"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	clustersv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func addPlacementDecisionDBSyncer(mgr ctrl.Manager, databaseConnectionPool *pgxpool.Pool,
-	syncInterval time.Duration) error {
-	err := mgr.Add(&genericDBSyncer{
-		syncInterval: syncInterval,
-		syncFunc: func(ctx context.Context) {
-			syncPlacementDecisions(ctx,
-				ctrl.Log.WithName("placement-decisions-db-syncer"),
-				databaseConnectionPool,
-				mgr.GetClient())
-		},
-	})
-	if err != nil {
-		return fmt.Errorf("failed to add placement-decisions syncer to the manager: %w", err)
-	}
+// placementDecisionDBSyncer creates the placement-decisions of the placements, aggregated from the leaf hubs.
+type placementDecisionDBSyncer struct{}
+
+func newPlacementDecisionDBSyncer(ctrl.Manager) (DBSyncer, error) {
+	return &placementDecisionDBSyncer{}, nil
+}
+
+func (syncer *placementDecisionDBSyncer) SpecGroupVersionKind() schema.GroupVersionKind {
+	return schema.FromAPIVersionAndKind(clustersv1beta1APIGroup, placementKind)
+}
+
+// TargetGroupVersionKind returns the kind of the placement-decisions generated for the spec objects.
+func (syncer *placementDecisionDBSyncer) TargetGroupVersionKind() schema.GroupVersionKind {
+	return schema.FromAPIVersionAndKind(clustersv1beta1APIGroup, placementDecisionKind)
+}
 
-	return nil
+func (syncer *placementDecisionDBSyncer) StatusTable() (string, string) {
+	// the key of a placement-decision is taken from its placement label, placement-decisions generated for
+	// placementrules do not have it.
+	return placementDecisionsStatusTableName, statusRowPlacementKey
 }
 
-func syncPlacementDecisions(ctx context.Context, log logr.Logger, databaseConnectionPool *pgxpool.Pool,
-	k8sClient client.Client) {
-	log.Info("performing sync of placement-decision")
+func (syncer *placementDecisionDBSyncer) ListSpecObjects(ctx context.Context, specStore SpecStore,
+	keys []string) ([]*SpecObject, error) {
+	return QuerySpecObjects(ctx, specStore, placementsSpecTableName, SpecRowNamespacedNameKey, keys)
+}
 
-	rows, err := databaseConnectionPool.Query(ctx,
-		fmt.Sprintf(`SELECT id, payload->'metadata'->>'name', payload->'metadata'->>'namespace' 
-		FROM spec.%s WHERE deleted = FALSE`, placementsSpecTableName))
+func (syncer *placementDecisionDBSyncer) FetchStatuses(ctx context.Context, statusStore StatusStore,
+	objects []*SpecObject) (map[string][]*LeafHubStatus, error) {
+	leafHubPlacementDecisions, err := FetchStatusPayloads(ctx, statusStore,
+		placementDecisionsStatusTableName, statusRowPlacementKey, objects,
+		func() interface{} { return &clustersv1beta1.PlacementDecision{} })
 	if err != nil {
-		log.Error(err, "error in getting placement spec")
-		return
+		return nil, fmt.Errorf("error in getting placement-decisions from DB - %w", err)
 	}
 
-	for rows.Next() {
-		var uid, name, namespace string
+	return leafHubPlacementDecisions, nil
+}
+
+// Aggregate returns the aggregated PlacementDecision, nil if no leaf hub reported a placement-decision of the
+// placement.
+func (syncer *placementDecisionDBSyncer) Aggregate(_ *SpecObject, leafHubStatuses []*LeafHubStatus) (interface{},
+	[]string) {
+	var aggregatedPlacementDecision *clustersv1beta1.PlacementDecision
 
-		err := rows.Scan(&uid, &name, &namespace)
-		if err != nil {
-			log.Error(err, "error in select", "table", placementsSpecTableName)
+	for _, leafHubStatus := range leafHubStatuses {
+		leafHubPlacementDecision, ok := leafHubStatus.Status.(*clustersv1beta1.PlacementDecision)
+		if !ok {
 			continue
 		}
 
-		go handlePlacementDecision(ctx, log, databaseConnectionPool, k8sClient, uid, name, namespace)
+		if aggregatedPlacementDecision == nil {
+			aggregatedPlacementDecision = leafHubPlacementDecision.DeepCopy()
+			continue
+		}
+
+		// the clusters reported by more than one leaf hub were resolved before the aggregation, so merge
+		aggregatedPlacementDecision.Status.Decisions = append(aggregatedPlacementDecision.Status.Decisions,
+			leafHubPlacementDecision.Status.Decisions...)
 	}
+
+	if aggregatedPlacementDecision == nil { // no status resources found in DB - if deleted then k8s handles it
+		return nil, nil
+	}
+
+	return aggregatedPlacementDecision, nil
 }
 
-func handlePlacementDecision(ctx context.Context, log logr.Logger, databaseConnectionPool *pgxpool.Pool,
-	k8sClient client.Client, specPlacementUID string, placementName string, placementNamespace string) {
-	log.Info("handling a placement", "name", placementName, "namespace", placementNamespace)
+// ClusterNames returns the decided clusters of the PlacementDecision.
+func (syncer *placementDecisionDBSyncer) ClusterNames(leafHubStatus interface{}) []string {
+	placementDecision, ok := leafHubStatus.(*clustersv1beta1.PlacementDecision)
+	if !ok {
+		return nil
+	}
 
-	placementDecision, err := getAggregatedPlacementDecisions(ctx, databaseConnectionPool, placementName,
-		placementNamespace)
-	if err != nil {
-		log.Error(err, "failed to get aggregated placement-decision", "name", placementName,
-			"namespace", placementNamespace)
+	return placementDecisionClusterNames(placementDecision)
+}
 
-		return
+// RewriteClusters returns a copy of the PlacementDecision with its decided clusters renamed or dropped.
+func (syncer *placementDecisionDBSyncer) RewriteClusters(leafHubStatus interface{},
+	rewrite func(clusterName string) (string, bool)) interface{} {
+	placementDecision, ok := leafHubStatus.(*clustersv1beta1.PlacementDecision)
+	if !ok {
+		return leafHubStatus
 	}
 
-	if placementDecision == nil { // no status resources found in DB - if deleted then k8s handles it by owner-reference
-		return
+	rewrittenPlacementDecision := placementDecision.DeepCopy()
+	rewrittenPlacementDecision.Status.Decisions = nil
+
+	for _, decision := range placementDecision.Status.Decisions {
+		if clusterName, keep := rewrite(decision.ClusterName); keep {
+			decision.ClusterName = clusterName
+			rewrittenPlacementDecision.Status.Decisions = append(rewrittenPlacementDecision.Status.Decisions, decision)
+		}
+	}
+
+	return rewrittenPlacementDecision
+}
+
+func (syncer *placementDecisionDBSyncer) Apply(ctx context.Context, k8sClient client.Client, object *SpecObject,
+	aggregatedStatus interface{}) (bool, error) {
+	placementDecision, ok := aggregatedStatus.(*clustersv1beta1.PlacementDecision)
+	if !ok {
+		return false, fmt.Errorf("%w: %T", errUnexpectedStatusType, aggregatedStatus)
 	}
 
 	// set owner-reference so that the placement-decision is deleted when the placement is
-	setOwnerReference(placementDecision, createOwnerReference(clustersv1beta1APIGroup, placementKind, placementName,
-		specPlacementUID))
+	setOwnerReference(placementDecision, createOwnerReference(clustersv1beta1APIGroup, placementKind, object.Name,
+		object.UID))
+
+	return updatePlacementDecision(ctx, k8sClient, placementDecision)
+}
 
-	if err := updatePlacementDecision(ctx, k8sClient, placementDecision); err != nil {
-		log.Error(err, "failed to update placement-decision")
+// MarkStale returns a copy of the PlacementDecision with the reason of its decisions set to a stale leaf hub.
+func (syncer *placementDecisionDBSyncer) MarkStale(leafHubStatus interface{}) interface{} {
+	placementDecision, ok := leafHubStatus.(*clustersv1beta1.PlacementDecision)
+	if !ok {
+		return leafHubStatus
 	}
+
+	stalePlacementDecision := placementDecision.DeepCopy()
+	for i := range stalePlacementDecision.Status.Decisions {
+		stalePlacementDecision.Status.Decisions[i].Reason = staleLeafHubReason
+	}
+
+	return stalePlacementDecision
 }
 
-// returns aggregated PlacementDecision and error.
-func getAggregatedPlacementDecisions(ctx context.Context, databaseConnectionPool *pgxpool.Pool,
-	placementName string, placementNamespace string) (*clustersv1beta1.PlacementDecision, error) {
-	rows, err := databaseConnectionPool.Query(ctx,
-		fmt.Sprintf(`SELECT payload FROM status.%s
-			WHERE payload->'metadata'->>'name' like $1 AND payload->'metadata'->>'namespace'=$2`,
-			placementDecisionsStatusTableName), fmt.Sprintf("%s%%", placementName), placementNamespace)
-	if err != nil {
-		return nil, fmt.Errorf("error in getting placement-decisions from DB - %w", err)
+// ClearStale returns a copy of the PlacementDecision without decisions.
+func (syncer *placementDecisionDBSyncer) ClearStale(leafHubStatus interface{}) interface{} {
+	placementDecision, ok := leafHubStatus.(*clustersv1beta1.PlacementDecision)
+	if !ok {
+		return leafHubStatus
 	}
 
-	defer rows.Close()
+	clearedPlacementDecision := placementDecision.DeepCopy()
+	// the decisions are required by the CRD
+	clearedPlacementDecision.Status.Decisions = []clustersv1beta1.ClusterDecision{}
 
-	// build an aggregated placement
-	var aggregatedPlacementDecision *clustersv1beta1.PlacementDecision
+	return clearedPlacementDecision
+}
 
-	for rows.Next() {
-		var leafHubPlacementDecision clustersv1beta1.PlacementDecision
+// ListSyncedObjects returns the placement-decisions created by this syncer, by the namespaced name of their placement.
+func (syncer *placementDecisionDBSyncer) ListSyncedObjects(ctx context.Context,
+	k8sReader client.Reader) ([]*SyncedObject, error) {
+	placementDecisions := &clustersv1beta1.PlacementDecisionList{}
 
-		if err := rows.Scan(&leafHubPlacementDecision); err != nil {
-			return nil, fmt.Errorf("error getting placement-decision from DB - %w", err)
-		}
+	if err := k8sReader.List(ctx, placementDecisions,
+		client.MatchingLabels{statusSyncLabelKey: statusSyncLabelValue}); err != nil {
+		return nil, newAPIError(operationList, fmt.Errorf("failed to list placement-decisions - %w", err))
+	}
 
-		if leafHubPlacementDecision.Labels[clustersv1beta1.PlacementLabel] != placementName {
-			continue // could be a PlacementDecision generated for a PlacementRule.
-		}
+	syncedObjects := make([]*SyncedObject, 0, len(placementDecisions.Items))
 
-		if aggregatedPlacementDecision == nil {
-			aggregatedPlacementDecision = leafHubPlacementDecision.DeepCopy()
-			continue
-		}
+	for i := range placementDecisions.Items {
+		placementDecision := &placementDecisions.Items[i]
+		placementName := placementDecision.Labels[clustersv1beta1.PlacementLabel]
 
-		// assuming that cluster names are unique across the hubs, all we need to do is a complete merge
-		aggregatedPlacementDecision.Status.Decisions = append(aggregatedPlacementDecision.Status.Decisions,
-			leafHubPlacementDecision.Status.Decisions...)
+		syncedObjects = append(syncedObjects, &SyncedObject{
+			Key:      namespacedNameKey(placementDecision.Namespace, placementName),
+			Object:   placementDecision,
+			OwnerUID: controllerUID(placementDecision),
+		})
 	}
 
-	return aggregatedPlacementDecision, nil
+	return syncedObjects, nil
+}
+
+// Collect deletes the placement-decision.
+func (syncer *placementDecisionDBSyncer) Collect(ctx context.Context, k8sClient client.Client,
+	object client.Object) error {
+	return deleteK8sResource(ctx, k8sClient, object)
 }
 
+func placementDecisionClusterNames(placementDecision *clustersv1beta1.PlacementDecision) []string {
+	clusterNames := make([]string, 0, len(placementDecision.Status.Decisions))
+
+	for _, decision := range placementDecision.Status.Decisions {
+		clusterNames = append(clusterNames, decision.ClusterName)
+	}
+
+	return clusterNames
+}
+
+// returns whether the placement-decision was created or patched, it is not patched if the status did not change.
 func updatePlacementDecision(ctx context.Context, k8sClient client.Client,
-	aggregatedPlacementDecision *clustersv1beta1.PlacementDecision) error {
+	aggregatedPlacementDecision *clustersv1beta1.PlacementDecision) (bool, error) {
 	deployedPlacementDecision := &clustersv1beta1.PlacementDecision{}
 
 	err := k8sClient.Get(ctx, client.ObjectKey{
@@ -136,15 +211,17 @@ func updatePlacementDecision(ctx context.Context, k8sClient client.Client,
 	if err != nil {
 		if errors.IsNotFound(err) {
 			if err := createK8sResource(ctx, k8sClient, aggregatedPlacementDecision); err != nil {
-				return fmt.Errorf("failed to create placement-decision {name=%s, namespace=%s} - %w",
-					aggregatedPlacementDecision.Name, aggregatedPlacementDecision.Namespace, err)
+				return false, newAPIError(operationCreate,
+					fmt.Errorf("failed to create placement-decision {name=%s, namespace=%s} - %w",
+						aggregatedPlacementDecision.Name, aggregatedPlacementDecision.Namespace, err))
 			}
 
-			return nil
+			return true, nil
 		}
 
-		return fmt.Errorf("failed to get placement-decision {name=%s, namespace=%s} - %w",
-			aggregatedPlacementDecision.Name, aggregatedPlacementDecision.Namespace, err)
+		return false, newAPIError(operationGet,
+			fmt.Errorf("failed to get placement-decision {name=%s, namespace=%s} - %w",
+				aggregatedPlacementDecision.Name, aggregatedPlacementDecision.Namespace, err))
 	}
 
 	// if object exists, clone and update
@@ -152,11 +229,16 @@ func updatePlacementDecision(ctx context.Context, k8sClient client.Client,
 
 	deployedPlacementDecision.Status.Decisions = aggregatedPlacementDecision.Status.Decisions
 
+	if equality.Semantic.DeepEqual(originalPlacementDecision.Status, deployedPlacementDecision.Status) {
+		return false, nil
+	}
+
 	err = k8sClient.Status().Patch(ctx, deployedPlacementDecision, client.MergeFrom(originalPlacementDecision))
 	if err != nil && !errors.IsNotFound(err) {
-		return fmt.Errorf("failed to update placement-decision CR (name=%s, namespace=%s): %w",
-			deployedPlacementDecision.Name, deployedPlacementDecision.Namespace, err)
+		return false, newAPIError(operationPatch,
+			fmt.Errorf("failed to update placement-decision CR (name=%s, namespace=%s): %w",
+				deployedPlacementDecision.Name, deployedPlacementDecision.Namespace, err))
 	}
 
-	return nil
+	return true, nil
 }
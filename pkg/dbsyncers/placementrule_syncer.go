@@ -6,11 +6,10 @@ package dbsyncers
 import (
 	"context"
 	"fmt"
-	"time"
 
-	"github.com/go-logr/logr"
-	"github.com/jackc/pgx/v4/pgxpool"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	placementrulesv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/placementrule/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -21,108 +20,160 @@ const (
 	placementrRulesStatusTableName = "placementrules"
 )
 
-func addPlacementRuleStatusDBSyncer(mgr ctrl.Manager, databaseConnectionPool *pgxpool.Pool,
-	syncInterval time.Duration) error {
-	err := mgr.Add(&genericDBSyncer{
-		syncInterval: syncInterval,
-		syncFunc: func(ctx context.Context) {
-			syncPlacementRules(ctx,
-				ctrl.Log.WithName("placementrule-db-syncer"),
-				databaseConnectionPool,
-				mgr.GetClient())
-		},
-	})
-	if err != nil {
-		return fmt.Errorf("failed to add placementrules syncer to the manager: %w", err)
-	}
+// placementRuleDBSyncer syncs the decisions of the placementrules.
+type placementRuleDBSyncer struct{}
+
+func newPlacementRuleDBSyncer(ctrl.Manager) (DBSyncer, error) {
+	return &placementRuleDBSyncer{}, nil
+}
 
-	return nil
+func (syncer *placementRuleDBSyncer) SpecGroupVersionKind() schema.GroupVersionKind {
+	return schema.FromAPIVersionAndKind(appsv1APIGroup, placementRuleKind)
 }
 
-func syncPlacementRules(ctx context.Context, log logr.Logger, databaseConnectionPool *pgxpool.Pool,
-	k8sClient client.Client) {
-	log.Info("performing sync of placementrule-status")
+func (syncer *placementRuleDBSyncer) StatusTable() (string, string) {
+	return placementrRulesStatusTableName, StatusRowNamespacedNameKey
+}
 
-	rows, err := databaseConnectionPool.Query(ctx,
-		fmt.Sprintf(`SELECT payload->'metadata'->>'name', payload->'metadata'->>'namespace' 
-		FROM spec.%s WHERE deleted = FALSE`, placementRulesSpecTableName))
+func (syncer *placementRuleDBSyncer) ListSpecObjects(ctx context.Context, specStore SpecStore,
+	keys []string) ([]*SpecObject, error) {
+	return QuerySpecObjects(ctx, specStore, placementRulesSpecTableName, SpecRowNamespacedNameKey, keys)
+}
+
+func (syncer *placementRuleDBSyncer) FetchStatuses(ctx context.Context, statusStore StatusStore,
+	objects []*SpecObject) (map[string][]*LeafHubStatus, error) {
+	leafHubPlacementRules, err := FetchStatusPayloads(ctx, statusStore, placementrRulesStatusTableName,
+		StatusRowNamespacedNameKey, objects, func() interface{} { return &placementrulesv1.PlacementRule{} })
 	if err != nil {
-		log.Error(err, "error in getting placementrule spec")
-		return
+		return nil, fmt.Errorf("error in getting placementrules from DB - %w", err)
+	}
+
+	return leafHubPlacementRules, nil
+}
+
+// Aggregate returns the aggregated PlacementRuleStatus, nil if no leaf hub reported the placementrule.
+func (syncer *placementRuleDBSyncer) Aggregate(_ *SpecObject, leafHubStatuses []*LeafHubStatus) (interface{},
+	[]string) {
+	if len(leafHubStatuses) == 0 { // no status resources found in DB - placementrule is never created here
+		return nil, nil
 	}
 
-	for rows.Next() {
-		var name, namespace string
+	placementRuleStatus := &placementrulesv1.PlacementRuleStatus{}
 
-		err := rows.Scan(&name, &namespace)
-		if err != nil {
-			log.Error(err, "error in select", "table", placementRulesSpecTableName)
+	for _, leafHubStatus := range leafHubStatuses {
+		leafHubPlacementRule, ok := leafHubStatus.Status.(*placementrulesv1.PlacementRule)
+		if !ok {
 			continue
 		}
 
-		go handlePlacementRuleStatus(ctx, log, databaseConnectionPool, k8sClient, name, namespace)
+		// the clusters reported by more than one leaf hub were resolved before the aggregation, so merge
+		placementRuleStatus.Decisions = append(placementRuleStatus.Decisions,
+			leafHubPlacementRule.Status.Decisions...)
 	}
+
+	return placementRuleStatus, nil
 }
 
-func handlePlacementRuleStatus(ctx context.Context, log logr.Logger, databaseConnectionPool *pgxpool.Pool,
-	k8sClient client.Client, placementRuleName string, placementRuleNamespace string) {
-	log.Info("handling a placementrule", "name", placementRuleName, "namespace", placementRuleNamespace)
+// ClusterNames returns the decided clusters of the PlacementRule.
+func (syncer *placementRuleDBSyncer) ClusterNames(leafHubStatus interface{}) []string {
+	placementRule, ok := leafHubStatus.(*placementrulesv1.PlacementRule)
+	if !ok {
+		return nil
+	}
 
-	placementRuleStatus, statusEntriesFound, err := getPlacementRuleStatus(ctx, databaseConnectionPool,
-		placementRuleName, placementRuleNamespace)
-	if err != nil {
-		log.Error(err, "failed to get placementrule status", "name", placementRuleName,
-			"namespace", placementRuleNamespace)
+	clusterNames := make([]string, 0, len(placementRule.Status.Decisions))
 
-		return
+	for _, decision := range placementRule.Status.Decisions {
+		clusterNames = append(clusterNames, decision.ClusterName)
 	}
 
-	if !statusEntriesFound { // no status resources found in DB - placementrule is never created here
-		return
+	return clusterNames
+}
+
+// RewriteClusters returns a copy of the PlacementRule with its decided clusters renamed or dropped.
+func (syncer *placementRuleDBSyncer) RewriteClusters(leafHubStatus interface{},
+	rewrite func(clusterName string) (string, bool)) interface{} {
+	placementRule, ok := leafHubStatus.(*placementrulesv1.PlacementRule)
+	if !ok {
+		return leafHubStatus
+	}
+
+	rewrittenPlacementRule := placementRule.DeepCopy()
+	rewrittenPlacementRule.Status.Decisions = nil
+
+	for _, decision := range placementRule.Status.Decisions {
+		if clusterName, keep := rewrite(decision.ClusterName); keep {
+			decision.ClusterName = clusterName
+			rewrittenPlacementRule.Status.Decisions = append(rewrittenPlacementRule.Status.Decisions, decision)
+		}
 	}
 
-	if err := updatePlacementRuleStatus(ctx, k8sClient, placementRuleName, placementRuleNamespace,
-		placementRuleStatus); err != nil {
-		log.Error(err, "failed to update placementrule status")
+	return rewrittenPlacementRule
+}
+
+// ClearStale returns a copy of the PlacementRule without decisions.
+func (syncer *placementRuleDBSyncer) ClearStale(leafHubStatus interface{}) interface{} {
+	placementRule, ok := leafHubStatus.(*placementrulesv1.PlacementRule)
+	if !ok {
+		return leafHubStatus
 	}
+
+	clearedPlacementRule := placementRule.DeepCopy()
+	clearedPlacementRule.Status.Decisions = nil
+
+	return clearedPlacementRule
 }
 
-// returns aggregated PlacementRuleStatus.
-func getPlacementRuleStatus(ctx context.Context, databaseConnectionPool *pgxpool.Pool,
-	placementRuleName string, placementRuleNamespace string) (*placementrulesv1.PlacementRuleStatus, bool, error) {
-	rows, err := databaseConnectionPool.Query(ctx,
-		fmt.Sprintf(`SELECT payload FROM status.%s
-			WHERE payload->'metadata'->>'name'=$1 AND payload->'metadata'->>'namespace'=$2`,
-			placementrRulesStatusTableName), placementRuleName, placementRuleNamespace)
-	if err != nil {
-		return nil, false, fmt.Errorf("error in getting placementrules from DB - %w", err)
+func (syncer *placementRuleDBSyncer) Apply(ctx context.Context, k8sClient client.Client, object *SpecObject,
+	aggregatedStatus interface{}) (bool, error) {
+	placementRuleStatus, ok := aggregatedStatus.(*placementrulesv1.PlacementRuleStatus)
+	if !ok {
+		return false, fmt.Errorf("%w: %T", errUnexpectedStatusType, aggregatedStatus)
 	}
 
-	defer rows.Close()
+	return updatePlacementRuleStatus(ctx, k8sClient, object.Name, object.Namespace, placementRuleStatus)
+}
 
-	// build an aggregated placement-rule
-	placementRuleStatus := &placementrulesv1.PlacementRuleStatus{}
-	statusEntriesFound := false
+// ListSyncedObjects returns the placementrules whose status was written by this syncer, by their namespaced name.
+func (syncer *placementRuleDBSyncer) ListSyncedObjects(ctx context.Context, k8sReader client.Reader) ([]*SyncedObject,
+	error) {
+	placementRules := &placementrulesv1.PlacementRuleList{}
 
-	for rows.Next() {
-		var leafHubPlacementRule placementrulesv1.PlacementRule
+	if err := k8sReader.List(ctx, placementRules,
+		client.MatchingLabels{statusSyncLabelKey: statusSyncLabelValue}); err != nil {
+		return nil, newAPIError(operationList, fmt.Errorf("failed to list placementrules - %w", err))
+	}
 
-		if err := rows.Scan(&leafHubPlacementRule); err != nil {
-			return nil, false, fmt.Errorf("error getting placementrule from DB - %w", err)
-		}
+	var syncedObjects []*SyncedObject
 
-		statusEntriesFound = true
+	for i := range placementRules.Items {
+		placementRule := &placementRules.Items[i]
 
-		// assuming that cluster names are unique across the hubs, all we need to do is a complete merge
-		placementRuleStatus.Decisions = append(placementRuleStatus.Decisions,
-			leafHubPlacementRule.Status.Decisions...)
+		if len(placementRule.Status.Decisions) == 0 { // nothing to clear
+			continue
+		}
+
+		syncedObjects = append(syncedObjects, &SyncedObject{
+			Key:    namespacedNameKey(placementRule.Namespace, placementRule.Name),
+			Object: placementRule,
+		})
 	}
 
-	return placementRuleStatus, statusEntriesFound, nil
+	return syncedObjects, nil
 }
 
+// Collect clears the status of the placementrule.
+func (syncer *placementRuleDBSyncer) Collect(ctx context.Context, k8sClient client.Client,
+	object client.Object) error {
+	_, err := updatePlacementRuleStatus(ctx, k8sClient, object.GetName(), object.GetNamespace(),
+		&placementrulesv1.PlacementRuleStatus{})
+
+	return err
+}
+
+// returns whether the placementrule status was patched, it is not if the status did not change.
 func updatePlacementRuleStatus(ctx context.Context, k8sClient client.Client, placementRuleName string,
-	placementRuleNamespace string, placementRuleStatus *placementrulesv1.PlacementRuleStatus) error {
+	placementRuleNamespace string, placementRuleStatus *placementrulesv1.PlacementRuleStatus) (bool, error) {
 	deployedPlacementRule := &placementrulesv1.PlacementRule{}
 
 	err := k8sClient.Get(ctx, client.ObjectKey{
@@ -131,11 +182,15 @@ func updatePlacementRuleStatus(ctx context.Context, k8sClient client.Client, pla
 	}, deployedPlacementRule)
 	if err != nil {
 		if errors.IsNotFound(err) { // CR getting deleted
-			return nil
+			return false, nil
 		}
 
-		return fmt.Errorf("failed to get placementrule {name=%s, namespace=%s} - %w",
-			placementRuleName, placementRuleNamespace, err)
+		return false, newAPIError(operationGet, fmt.Errorf("failed to get placementrule {name=%s, namespace=%s} - %w",
+			placementRuleName, placementRuleNamespace, err))
+	}
+
+	if err := labelStatusSynced(ctx, k8sClient, deployedPlacementRule); err != nil {
+		return false, err
 	}
 
 	// if object exists, clone and update
@@ -143,11 +198,16 @@ func updatePlacementRuleStatus(ctx context.Context, k8sClient client.Client, pla
 
 	deployedPlacementRule.Status = *placementRuleStatus
 
+	if equality.Semantic.DeepEqual(originalPlacementRule.Status, deployedPlacementRule.Status) {
+		return false, nil
+	}
+
 	err = k8sClient.Status().Patch(ctx, deployedPlacementRule, client.MergeFrom(originalPlacementRule))
 	if err != nil && !errors.IsNotFound(err) {
-		return fmt.Errorf("failed to update placementrule CR (name=%s, namespace=%s): %w",
-			deployedPlacementRule.Name, deployedPlacementRule.Namespace, err)
+		return false, newAPIError(operationPatch,
+			fmt.Errorf("failed to update placementrule CR (name=%s, namespace=%s): %w",
+				deployedPlacementRule.Name, deployedPlacementRule.Namespace, err))
 	}
 
-	return nil
+	return true, nil
 }
@@ -6,12 +6,11 @@ package dbsyncers
 import (
 	"context"
 	"fmt"
-	"time"
 
-	"github.com/go-logr/logr"
-	"github.com/jackc/pgx/v4/pgxpool"
 	policiesv1 "github.com/open-cluster-management/governance-policy-propagator/api/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -24,120 +23,199 @@ const (
 	complianceStatusTableName = "compliance"
 )
 
-func addPolicyDBSyncer(mgr ctrl.Manager, databaseConnectionPool *pgxpool.Pool, syncInterval time.Duration) error {
-	err := mgr.Add(&genericDBSyncer{
-		syncInterval: syncInterval,
-		syncFunc: func(ctx context.Context) {
-			syncPolicies(ctx,
-				ctrl.Log.WithName("policies-db-syncer"),
-				databaseConnectionPool, mgr.GetClient(),
-				map[string]policiesv1.ComplianceState{
-					dbEnumCompliant:    policiesv1.Compliant,
-					dbEnumNonCompliant: policiesv1.NonCompliant,
-				})
+// policyDBSyncer syncs the compliance status of the policies.
+type policyDBSyncer struct {
+	dbEnumToPolicyComplianceStateMap map[string]policiesv1.ComplianceState
+}
+
+func newPolicyDBSyncer(ctrl.Manager) (DBSyncer, error) {
+	return &policyDBSyncer{
+		dbEnumToPolicyComplianceStateMap: map[string]policiesv1.ComplianceState{
+			dbEnumCompliant:    policiesv1.Compliant,
+			dbEnumNonCompliant: policiesv1.NonCompliant,
 		},
-	})
-	if err != nil {
-		return fmt.Errorf("failed to add policies status syncer to the manager: %w", err)
-	}
+	}, nil
+}
+
+func (syncer *policyDBSyncer) SpecGroupVersionKind() schema.GroupVersionKind {
+	return policiesv1.GroupVersion.WithKind(policyKind)
+}
 
-	return nil
+func (syncer *policyDBSyncer) StatusTable() (string, string) {
+	return complianceStatusTableName, StatusRowIDKey
 }
 
-func syncPolicies(ctx context.Context, log logr.Logger, databaseConnectionPool *pgxpool.Pool, k8sClient client.Client,
-	dbEnumToPolicyComplianceStateMap map[string]policiesv1.ComplianceState) {
-	log.Info("performing sync of policies status")
+func (syncer *policyDBSyncer) ListSpecObjects(ctx context.Context, specStore SpecStore,
+	keys []string) ([]*SpecObject, error) {
+	return QuerySpecObjects(ctx, specStore, policiesSpecTableName, SpecRowIDKey, keys)
+}
 
-	rows, err := databaseConnectionPool.Query(ctx,
-		fmt.Sprintf(`SELECT id, payload->'metadata'->>'name', payload->'metadata'->>'namespace' 
-		FROM spec.%s WHERE deleted = FALSE`, policiesSpecTableName))
+// FetchStatuses returns the CompliancePerClusterStatus of each policy by its uid, ordered by leaf hub and cluster.
+func (syncer *policyDBSyncer) FetchStatuses(ctx context.Context, statusStore StatusStore,
+	objects []*SpecObject) (map[string][]*LeafHubStatus, error) {
+	rows, err := statusStore.StatusRows(ctx, complianceStatusTableName, StatusRowIDKey, StatusColumnsCompliance,
+		specObjectKeys(objects))
 	if err != nil {
-		log.Error(err, "error in getting policies spec")
-		return
+		return nil, fmt.Errorf("error in getting policy compliance statuses from DB - %w", err)
 	}
 
-	for rows.Next() {
-		var id, name, namespace string
+	compliancePerClusterStatuses := map[string][]*LeafHubStatus{}
 
-		err := rows.Scan(&id, &name, &namespace)
-		if err != nil {
-			log.Error(err, "error in select", "table", policiesSpecTableName)
-			continue
-		}
+	for _, row := range rows {
+		compliancePerClusterStatuses[row.Key] = append(compliancePerClusterStatuses[row.Key], &LeafHubStatus{
+			LeafHubName: row.LeafHubName,
+			Status: &policiesv1.CompliancePerClusterStatus{
+				ComplianceState:  syncer.dbEnumToPolicyComplianceStateMap[row.Compliance],
+				ClusterName:      row.ClusterName,
+				ClusterNamespace: row.ClusterName,
+			},
+		})
+	}
+
+	return compliancePerClusterStatuses, nil
+}
 
-		instance := &policiesv1.Policy{}
+// Aggregate returns the policyComplianceStatus of the policy, policies without compliance status rows get an empty
+// status so that their status is cleared.
+func (syncer *policyDBSyncer) Aggregate(_ *SpecObject, leafHubStatuses []*LeafHubStatus) (interface{}, []string) {
+	complianceStatus := &policyComplianceStatus{}
 
-		err = k8sClient.Get(ctx, client.ObjectKey{Name: name, Namespace: namespace}, instance)
-		if err != nil {
-			log.Error(err, "error in getting CR", "name", name, "namespace", namespace)
+	for _, leafHubStatus := range leafHubStatuses {
+		compliancePerClusterStatus, ok := leafHubStatus.Status.(*policiesv1.CompliancePerClusterStatus)
+		if !ok {
 			continue
 		}
 
-		go handlePolicy(ctx, log, databaseConnectionPool, k8sClient, dbEnumToPolicyComplianceStateMap, instance)
+		if compliancePerClusterStatus.ComplianceState == policiesv1.NonCompliant {
+			complianceStatus.hasNonCompliantClusters = true
+		}
+
+		complianceStatus.compliancePerClusterStatuses = append(complianceStatus.compliancePerClusterStatuses,
+			compliancePerClusterStatus)
 	}
+
+	return complianceStatus, nil
 }
 
-func handlePolicy(ctx context.Context, log logr.Logger, databaseConnectionPool *pgxpool.Pool,
-	k8sClient client.StatusClient, dbEnumToPolicyComplianceStateMap map[string]policiesv1.ComplianceState,
-	policy *policiesv1.Policy) {
-	compliancePerClusterStatuses, hasNonCompliantClusters, err := getComplianceStatus(ctx, databaseConnectionPool,
-		dbEnumToPolicyComplianceStateMap, policy)
-	if err != nil {
-		log.Error(err, "failed to get compliance status of a policy", "uid", policy.GetUID())
-		return
+// ClusterNames returns the cluster of the CompliancePerClusterStatus.
+func (syncer *policyDBSyncer) ClusterNames(leafHubStatus interface{}) []string {
+	compliancePerClusterStatus, ok := leafHubStatus.(*policiesv1.CompliancePerClusterStatus)
+	if !ok {
+		return nil
 	}
 
-	if err = updateComplianceStatus(ctx, k8sClient, policy, compliancePerClusterStatuses,
-		hasNonCompliantClusters); err != nil {
-		log.Error(err, "failed to update policy status")
-	}
+	return []string{compliancePerClusterStatus.ClusterName}
 }
 
-// returns array of CompliancePerClusterStatus, whether the policy has any NonCompliant cluster, and error.
-func getComplianceStatus(ctx context.Context, databaseConnectionPool *pgxpool.Pool,
-	dbEnumToPolicyComplianceStateMap map[string]policiesv1.ComplianceState,
-	policy *policiesv1.Policy) ([]*policiesv1.CompliancePerClusterStatus, bool, error) {
-	rows, err := databaseConnectionPool.Query(ctx,
-		fmt.Sprintf(`SELECT cluster_name,leaf_hub_name,compliance FROM status.%s
-			WHERE id=$1 ORDER BY leaf_hub_name, cluster_name`, complianceStatusTableName), string(policy.GetUID()))
-	if err != nil {
-		return []*policiesv1.CompliancePerClusterStatus{}, false,
-			fmt.Errorf("error in getting policy compliance statuses from DB - %w", err)
+// RewriteClusters returns a copy of the CompliancePerClusterStatus with its cluster renamed, nil if it is dropped.
+func (syncer *policyDBSyncer) RewriteClusters(leafHubStatus interface{},
+	rewrite func(clusterName string) (string, bool)) interface{} {
+	compliancePerClusterStatus, ok := leafHubStatus.(*policiesv1.CompliancePerClusterStatus)
+	if !ok {
+		return leafHubStatus
 	}
 
-	defer rows.Close()
+	clusterName, keep := rewrite(compliancePerClusterStatus.ClusterName)
+	if !keep {
+		return nil
+	}
 
-	var compliancePerClusterStatuses []*policiesv1.CompliancePerClusterStatus
+	rewrittenCompliancePerClusterStatus := compliancePerClusterStatus.DeepCopy()
+	rewrittenCompliancePerClusterStatus.ClusterName = clusterName
 
-	hasNonCompliantClusters := false
+	return rewrittenCompliancePerClusterStatus
+}
+
+func (syncer *policyDBSyncer) Apply(ctx context.Context, k8sClient client.Client, object *SpecObject,
+	aggregatedStatus interface{}) (bool, error) {
+	complianceStatus, ok := aggregatedStatus.(*policyComplianceStatus)
+	if !ok {
+		return false, fmt.Errorf("%w: %T", errUnexpectedStatusType, aggregatedStatus)
+	}
 
-	for rows.Next() {
-		var clusterName, leafHubName, complianceInDB string
+	policy := &policiesv1.Policy{}
 
-		if err := rows.Scan(&clusterName, &leafHubName, &complianceInDB); err != nil {
-			return []*policiesv1.CompliancePerClusterStatus{}, false,
-				fmt.Errorf("error in getting policy compliance statuses from DB - %w", err)
+	if err := k8sClient.Get(ctx, client.ObjectKey{Name: object.Name, Namespace: object.Namespace}, policy); err != nil {
+		if errors.IsNotFound(err) { // CR getting deleted
+			return false, nil
 		}
 
-		compliance := dbEnumToPolicyComplianceStateMap[complianceInDB]
+		return false, newAPIError(operationGet, fmt.Errorf("failed to get policy {name=%s, namespace=%s} - %w",
+			object.Name, object.Namespace, err))
+	}
+
+	if err := labelStatusSynced(ctx, k8sClient, policy); err != nil {
+		return false, err
+	}
 
-		if compliance == policiesv1.NonCompliant {
-			hasNonCompliantClusters = true
+	return updateComplianceStatus(ctx, k8sClient, policy, complianceStatus.compliancePerClusterStatuses,
+		complianceStatus.hasNonCompliantClusters)
+}
+
+// ListSyncedObjects returns the root policies whose compliance status was written by this syncer, by their uid.
+func (syncer *policyDBSyncer) ListSyncedObjects(ctx context.Context, k8sReader client.Reader) ([]*SyncedObject,
+	error) {
+	policies := &policiesv1.PolicyList{}
+
+	if err := k8sReader.List(ctx, policies,
+		client.MatchingLabels{statusSyncLabelKey: statusSyncLabelValue}); err != nil {
+		return nil, newAPIError(operationList, fmt.Errorf("failed to list policies - %w", err))
+	}
+
+	var syncedObjects []*SyncedObject
+
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+
+		if _, found := policy.Labels[replicatedPolicyLabelKey]; found { // the status is not written by this syncer
+			continue
 		}
 
-		compliancePerClusterStatuses = append(compliancePerClusterStatuses, &policiesv1.CompliancePerClusterStatus{
-			ComplianceState:  compliance,
-			ClusterName:      clusterName,
-			ClusterNamespace: clusterName,
-		})
+		if len(policy.Status.Status) == 0 && policy.Status.ComplianceState == "" { // nothing to clear
+			continue
+		}
+
+		syncedObjects = append(syncedObjects, &SyncedObject{Key: string(policy.UID), Object: policy})
+	}
+
+	return syncedObjects, nil
+}
+
+// Collect clears the compliance status of the policy.
+func (syncer *policyDBSyncer) Collect(ctx context.Context, k8sClient client.Client, object client.Object) error {
+	policy, ok := object.(*policiesv1.Policy)
+	if !ok {
+		return fmt.Errorf("%w: %T", errUnexpectedObjectType, object)
 	}
 
-	return compliancePerClusterStatuses, hasNonCompliantClusters, nil
+	_, err := updateComplianceStatus(ctx, k8sClient, policy, nil, false)
+
+	return err
 }
 
+// MarkStale returns a copy of the CompliancePerClusterStatus with an unknown compliance state.
+func (syncer *policyDBSyncer) MarkStale(leafHubStatus interface{}) interface{} {
+	compliancePerClusterStatus, ok := leafHubStatus.(*policiesv1.CompliancePerClusterStatus)
+	if !ok {
+		return leafHubStatus
+	}
+
+	staleCompliancePerClusterStatus := compliancePerClusterStatus.DeepCopy()
+	staleCompliancePerClusterStatus.ComplianceState = ""
+
+	return staleCompliancePerClusterStatus
+}
+
+// policyComplianceStatus is the compliance status of a policy, aggregated from the compliance status table.
+type policyComplianceStatus struct {
+	compliancePerClusterStatuses []*policiesv1.CompliancePerClusterStatus
+	hasNonCompliantClusters      bool
+}
+
+// returns whether the policy status was patched, it is not if the status did not change.
 func updateComplianceStatus(ctx context.Context, k8sClient client.StatusClient, policy *policiesv1.Policy,
 	compliancePerClusterStatuses []*policiesv1.CompliancePerClusterStatus,
-	hasNonCompliantClusters bool) error {
+	hasNonCompliantClusters bool) (bool, error) {
 	originalPolicy := policy.DeepCopy()
 
 	policy.Status.Status = compliancePerClusterStatuses
@@ -149,10 +227,14 @@ func updateComplianceStatus(ctx context.Context, k8sClient client.StatusClient,
 		policy.Status.ComplianceState = policiesv1.Compliant
 	}
 
+	if equality.Semantic.DeepEqual(originalPolicy.Status, policy.Status) {
+		return false, nil
+	}
+
 	err := k8sClient.Status().Patch(ctx, policy, client.MergeFrom(originalPolicy))
 	if err != nil && !errors.IsNotFound(err) {
-		return fmt.Errorf("failed to update policy CR: %w", err)
+		return false, newAPIError(operationPatch, fmt.Errorf("failed to update policy CR: %w", err))
 	}
 
-	return nil
+	return true, nil
 }
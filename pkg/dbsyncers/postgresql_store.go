@@ -0,0 +1,281 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// deletedStatusRowsTableName records the deleted rows of the status tables, see deploy/status-updated-at.sql.
+const deletedStatusRowsTableName = "deleted_status_rows"
+
+var errUnknownStatusColumns = errors.New("unknown status columns")
+
+// PostgreSQLStore reads the spec and status tables of the hub-of-hubs PostgreSQL database.
+type PostgreSQLStore struct {
+	databaseConnectionPool *pgxpool.Pool
+}
+
+// NewPostgreSQLStore returns a Store that queries the database by the connection pool.
+func NewPostgreSQLStore(databaseConnectionPool *pgxpool.Pool) *PostgreSQLStore {
+	return &PostgreSQLStore{databaseConnectionPool: databaseConnectionPool}
+}
+
+// SpecObjects returns the non-deleted objects of a spec table, keyed by keyExpression.
+func (store *PostgreSQLStore) SpecObjects(ctx context.Context, tableName string, keyExpression string,
+	keys []string) ([]*SpecObject, error) {
+	rows, err := store.querySpecRows(ctx, tableName,
+		fmt.Sprintf(`%s, id, payload->'metadata'->>'name', payload->'metadata'->>'namespace'`, keyExpression),
+		keyExpression, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var objects []*SpecObject
+
+	for rows.Next() {
+		object := &SpecObject{}
+
+		if err := rows.Scan(&object.Key, &object.UID, &object.Name, &object.Namespace); err != nil {
+			return nil, fmt.Errorf("failed to scan spec.%s - %w", tableName, err)
+		}
+
+		objects = append(objects, object)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan spec.%s - %w", tableName, err)
+	}
+
+	return objects, nil
+}
+
+// querySpecRows selects columns of the non-deleted rows of a spec table. if keys is not nil, only the rows whose
+// keyExpression evaluates to one of the keys are selected.
+func (store *PostgreSQLStore) querySpecRows(ctx context.Context, tableName string, columns string,
+	keyExpression string, keys []string) (pgx.Rows, error) {
+	if keys == nil {
+		rows, err := store.databaseConnectionPool.Query(ctx,
+			fmt.Sprintf(`SELECT %s FROM spec.%s WHERE deleted = FALSE`, columns, tableName))
+		if err != nil {
+			return nil, fmt.Errorf("failed to query spec.%s - %w", tableName, err)
+		}
+
+		return rows, nil
+	}
+
+	rows, err := store.databaseConnectionPool.Query(ctx,
+		fmt.Sprintf(`SELECT %s FROM spec.%s WHERE deleted = FALSE AND %s`, columns, tableName,
+			keysCondition(keyExpression)), keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query spec.%s - %w", tableName, err)
+	}
+
+	return rows, nil
+}
+
+// keysCondition returns the condition that the keyExpression of a row evaluates to one of the keys in $1. the id
+// column is compared as a uuid rather than by its text cast, so that its index can be used.
+func keysCondition(keyExpression string) string {
+	if keyExpression == SpecRowIDKey {
+		return `id = ANY($1::uuid[])`
+	}
+
+	return fmt.Sprintf(`(%s) = ANY($1)`, keyExpression)
+}
+
+// StatusRows returns the rows of a status table whose keyExpression evaluates to one of the keys.
+func (store *PostgreSQLStore) StatusRows(ctx context.Context, tableName string, keyExpression string,
+	columns StatusColumns, keys []string) ([]*StatusRow, error) {
+	var selectedColumns, orderBy string
+
+	switch columns {
+	case StatusColumnsPayload:
+		selectedColumns, orderBy = "leaf_hub_name, payload", "leaf_hub_name"
+	case StatusColumnsCompliance:
+		selectedColumns, orderBy = "leaf_hub_name, cluster_name, compliance", "leaf_hub_name, cluster_name"
+	default:
+		return nil, fmt.Errorf("%w: %q", errUnknownStatusColumns, columns)
+	}
+
+	rows, err := store.databaseConnectionPool.Query(ctx,
+		fmt.Sprintf(`SELECT %s, %s FROM status.%s WHERE %s ORDER BY 1, %s`, keyExpression, selectedColumns,
+			tableName, keysCondition(keyExpression), orderBy), keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query status.%s - %w", tableName, err)
+	}
+
+	defer rows.Close()
+
+	var statusRows []*StatusRow
+
+	for rows.Next() {
+		statusRow := &StatusRow{}
+
+		if columns == StatusColumnsPayload {
+			err = rows.Scan(&statusRow.Key, &statusRow.LeafHubName, &statusRow.Payload)
+		} else {
+			err = rows.Scan(&statusRow.Key, &statusRow.LeafHubName, &statusRow.ClusterName, &statusRow.Compliance)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan status.%s - %w", tableName, err)
+		}
+
+		statusRows = append(statusRows, statusRow)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan status.%s - %w", tableName, err)
+	}
+
+	return statusRows, nil
+}
+
+// ChangedStatusKeys returns the keys of the objects whose status rows were updated or deleted after since, minus
+// statusChangesOverlap, and the latest update time of these rows. the deleted rows are recorded by
+// deploy/status-updated-at.sql.
+func (store *PostgreSQLStore) ChangedStatusKeys(ctx context.Context, tableName string, keyExpression string,
+	since time.Time) ([]string, time.Time, error) {
+	rows, err := store.databaseConnectionPool.Query(ctx,
+		fmt.Sprintf(`SELECT key, max(updated_at) FROM (
+				SELECT %s AS key, updated_at FROM status.%s WHERE updated_at > $1
+				UNION ALL
+				SELECT %s, deleted_at FROM status.%s WHERE table_name = $2 AND deleted_at > $1
+			) AS changes WHERE key IS NOT NULL GROUP BY 1`, keyExpression, tableName, keyExpression,
+			deletedStatusRowsTableName), since.Add(-statusChangesOverlap), tableName)
+	if err != nil {
+		return nil, since, fmt.Errorf("failed to query changes of status.%s - %w", tableName, err)
+	}
+
+	defer rows.Close()
+
+	var keys []string
+
+	watermark := since
+
+	for rows.Next() {
+		var (
+			key       string
+			updatedAt time.Time
+		)
+
+		if err := rows.Scan(&key, &updatedAt); err != nil {
+			return nil, since, fmt.Errorf("failed to scan changes of status.%s - %w", tableName, err)
+		}
+
+		keys = append(keys, key)
+
+		if updatedAt.After(watermark) {
+			watermark = updatedAt
+		}
+	}
+
+	// the watermark must not move past the rows that were not read
+	if err := rows.Err(); err != nil {
+		return nil, since, fmt.Errorf("failed to scan changes of status.%s - %w", tableName, err)
+	}
+
+	return keys, watermark, nil
+}
+
+// StatusWatermark returns the latest update time of the rows of a status table.
+func (store *PostgreSQLStore) StatusWatermark(ctx context.Context, tableName string) (time.Time, error) {
+	var watermark time.Time
+
+	if err := store.databaseConnectionPool.QueryRow(ctx,
+		fmt.Sprintf(`SELECT COALESCE(max(updated_at), to_timestamp(0)) FROM status.%s`, tableName),
+	).Scan(&watermark); err != nil {
+		return time.Time{}, fmt.Errorf("failed to query watermark of status.%s - %w", tableName, err)
+	}
+
+	return watermark, nil
+}
+
+// LeafHubUpdateTimes returns the last update time of the status rows of each leaf hub, by the key of the spec object
+// they belong to.
+func (store *PostgreSQLStore) LeafHubUpdateTimes(ctx context.Context, tableName string, keyExpression string,
+	keys []string) (map[string]map[string]time.Time, error) {
+	rows, err := store.databaseConnectionPool.Query(ctx,
+		fmt.Sprintf(`SELECT %s, leaf_hub_name, max(updated_at) FROM status.%s WHERE %s GROUP BY 1, 2`,
+			keyExpression, tableName, keysCondition(keyExpression)), keys)
+	if err != nil {
+		return nil, fmt.Errorf("error in getting leaf hub update times from status.%s - %w", tableName, err)
+	}
+
+	defer rows.Close()
+
+	leafHubUpdateTimes := map[string]map[string]time.Time{}
+
+	for rows.Next() {
+		var (
+			key, leafHubName string
+			updateTime       time.Time
+		)
+
+		if err := rows.Scan(&key, &leafHubName, &updateTime); err != nil {
+			return nil, fmt.Errorf("error in getting leaf hub update times from status.%s - %w", tableName, err)
+		}
+
+		if _, found := leafHubUpdateTimes[key]; !found {
+			leafHubUpdateTimes[key] = map[string]time.Time{}
+		}
+
+		leafHubUpdateTimes[key][leafHubName] = updateTime
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error in getting leaf hub update times from status.%s - %w", tableName, err)
+	}
+
+	return leafHubUpdateTimes, nil
+}
+
+// StaleLeafHubs returns the names of the leaf hubs whose last heartbeat is older than the threshold.
+func (store *PostgreSQLStore) StaleLeafHubs(ctx context.Context,
+	threshold time.Duration) (map[string]struct{}, error) {
+	rows, err := store.databaseConnectionPool.Query(ctx, fmt.Sprintf(
+		`SELECT name FROM status.%s WHERE last_timestamp < now() - $1 * interval '1 second'`,
+		leafHubHeartbeatsTableName), threshold.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("error in getting stale leaf hubs from DB - %w", err)
+	}
+
+	defer rows.Close()
+
+	staleLeafHubs := map[string]struct{}{}
+
+	for rows.Next() {
+		var leafHubName string
+
+		if err := rows.Scan(&leafHubName); err != nil {
+			return nil, fmt.Errorf("error in getting stale leaf hubs from DB - %w", err)
+		}
+
+		staleLeafHubs[leafHubName] = struct{}{}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error in getting stale leaf hubs from DB - %w", err)
+	}
+
+	return staleLeafHubs, nil
+}
+
+// Ping checks that the database is reachable.
+func (store *PostgreSQLStore) Ping(ctx context.Context) error {
+	if err := store.databaseConnectionPool.Ping(ctx); err != nil {
+		return fmt.Errorf("failed to ping PostgreSQL - %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"fmt"
+	"sort"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// DBSyncerFactory returns a DBSyncer to be run by the manager, e.g. after adding its kinds to the manager scheme.
+type DBSyncerFactory func(mgr ctrl.Manager) (DBSyncer, error)
+
+// Registry holds the factories of the DBSyncers that AddDBSyncers adds to the manager, by their names.
+type Registry struct {
+	factories map[string]DBSyncerFactory
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{factories: map[string]DBSyncerFactory{}}
+}
+
+// NewDefaultRegistry returns a Registry of the built-in DBSyncers, more DBSyncers can be registered to it.
+func NewDefaultRegistry() *Registry {
+	return &Registry{
+		factories: map[string]DBSyncerFactory{
+			policiesDBSyncerName:             newPolicyDBSyncer,
+			placementRulesDBSyncerName:       newPlacementRuleDBSyncer,
+			placementsDBSyncerName:           newPlacementDBSyncer,
+			placementDecisionsDBSyncerName:   newPlacementDecisionDBSyncer,
+			subscriptionStatusesDBSyncerName: newSubscriptionStatusDBSyncer,
+			subscriptionReportsDBSyncerName:  newSubscriptionReportDBSyncer,
+		},
+	}
+}
+
+// Register adds a DBSyncer factory under a unique name.
+func (registry *Registry) Register(name string, factory DBSyncerFactory) error {
+	if name == "" || factory == nil {
+		return fmt.Errorf("%w: DB syncer must have a name and a factory", errInvalidConfiguration)
+	}
+
+	if _, found := registry.factories[name]; found {
+		return fmt.Errorf("%w: DB syncer %s is already registered", errInvalidConfiguration, name)
+	}
+
+	registry.factories[name] = factory
+
+	return nil
+}
+
+// Names returns the sorted names of the registered DBSyncers.
+func (registry *Registry) Names() []string {
+	names := make([]string, 0, len(registry.factories))
+
+	for name := range registry.factories {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
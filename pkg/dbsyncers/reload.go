@@ -0,0 +1,148 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// Reloader applies the sync intervals, the concurrency limits and the enabled DB syncers of a new Config to the
+// running DB syncers. the other settings of the Config require a restart and are ignored.
+type Reloader struct {
+	lock sync.Mutex
+	log  logr.Logger
+	// config is the Config the DB syncers run with, nil until AddDBSyncers is called.
+	config  *Config
+	syncers map[string]*genericDBSyncer
+}
+
+// NewReloader returns a Reloader to be set in the Config of AddDBSyncers.
+func NewReloader() *Reloader {
+	return &Reloader{syncers: map[string]*genericDBSyncer{}}
+}
+
+// register adds a DB syncer to be reloaded. must be called before start.
+func (reloader *Reloader) register(syncerName string, syncer *genericDBSyncer) {
+	reloader.syncers[syncerName] = syncer
+}
+
+// start sets the Config the DB syncers were added with.
+func (reloader *Reloader) start(log logr.Logger, config *Config) {
+	reloader.lock.Lock()
+	defer reloader.lock.Unlock()
+
+	reloader.log = log
+	reloader.config = config
+}
+
+// Reload applies the reloadable settings of the Config to the running DB syncers, and logs those that changed.
+func (reloader *Reloader) Reload(config *Config) error {
+	reloader.lock.Lock()
+	defer reloader.lock.Unlock()
+
+	if reloader.config == nil {
+		return fmt.Errorf("%w: the DB syncers were not added with the reloader", errInvalidConfiguration)
+	}
+
+	if config.SyncInterval <= 0 || config.SyncerConcurrency <= 0 {
+		return fmt.Errorf("%w: the sync interval and the syncer concurrency must be positive", errInvalidConfiguration)
+	}
+
+	for syncerName, syncerConfig := range config.Syncers {
+		if _, found := reloader.syncers[syncerName]; !found {
+			return fmt.Errorf("%w: unknown DB syncer %s", errInvalidConfiguration, syncerName)
+		}
+
+		if syncerConfig.SyncInterval < 0 || syncerConfig.Concurrency < 0 {
+			return fmt.Errorf("%w: the sync interval and the concurrency of DB syncer %s must not be negative",
+				errInvalidConfiguration, syncerName)
+		}
+	}
+
+	reloadedConfig := *reloader.config // the modes the DB syncers run with can not be reloaded
+	reloadedConfig.SyncInterval = config.SyncInterval
+	reloadedConfig.SyncerConcurrency = config.SyncerConcurrency
+	reloadedConfig.Syncers = config.Syncers
+
+	syncerNames := make([]string, 0, len(reloader.syncers))
+	for syncerName := range reloader.syncers {
+		syncerNames = append(syncerNames, syncerName)
+	}
+
+	sort.Strings(syncerNames)
+
+	for _, syncerName := range syncerNames {
+		syncerConfig := reloadedConfig.Syncers[syncerName]
+		reloader.reloadSyncer(syncerName, reloader.syncers[syncerName], &reloadedConfig, &syncerConfig)
+	}
+
+	reloader.config = &reloadedConfig
+
+	return nil
+}
+
+func (reloader *Reloader) reloadSyncer(syncerName string, syncer *genericDBSyncer, config *Config,
+	syncerConfig *SyncerConfig) {
+	log := reloader.log.WithValues("syncer", syncerName)
+
+	concurrency := syncerConcurrency(config, syncerConfig)
+	if oldConcurrency := syncer.workers.getConcurrency(); concurrency != oldConcurrency {
+		if err := syncer.workers.setConcurrency(concurrency); err != nil {
+			log.Error(err, "failed to reload concurrency")
+		} else {
+			log.Info("reloaded concurrency", "old", oldConcurrency, "new", concurrency)
+		}
+	}
+
+	syncInterval := syncerSyncInterval(config, syncerConfig)
+	oldSyncInterval, oldPaused := syncer.currentSyncInterval(), syncer.isPaused()
+
+	if syncInterval == oldSyncInterval && syncerConfig.Disabled == oldPaused {
+		return
+	}
+
+	syncer.updateSettings(syncInterval, syncerConfig.Disabled)
+
+	if syncInterval != oldSyncInterval {
+		log.Info("reloaded sync interval", "old", oldSyncInterval.String(), "new", syncInterval.String())
+	}
+
+	if syncerConfig.Disabled != oldPaused {
+		log.Info("reloaded enabled", "old", !oldPaused, "new", !syncerConfig.Disabled)
+	}
+}
+
+// currentSyncInterval returns the interval of the periodic sync, which can be changed by a configuration reload.
+func (syncer *genericDBSyncer) currentSyncInterval() time.Duration {
+	syncer.settingsLock.RLock()
+	defer syncer.settingsLock.RUnlock()
+
+	return syncer.syncInterval
+}
+
+// isPaused returns whether the syncer is disabled, the periodic sync then skips the syncs until it is enabled.
+func (syncer *genericDBSyncer) isPaused() bool {
+	syncer.settingsLock.RLock()
+	defer syncer.settingsLock.RUnlock()
+
+	return syncer.paused
+}
+
+// updateSettings changes the settings of the syncer and wakes up its periodic sync to apply them.
+func (syncer *genericDBSyncer) updateSettings(syncInterval time.Duration, paused bool) {
+	syncer.settingsLock.Lock()
+	syncer.syncInterval = syncInterval
+	syncer.paused = paused
+	syncer.settingsLock.Unlock()
+
+	select {
+	case syncer.settingsChanged <- struct{}{}:
+	default: // the periodic sync was already woken up, it reads the latest settings
+	}
+}
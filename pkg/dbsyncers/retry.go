@@ -0,0 +1,81 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	retryBackoffFactor = 2
+	retryBackoffJitter = 0.1
+)
+
+// retryPolicy is the exponential backoff of the retries of the status writes that failed with a transient error.
+type retryPolicy struct {
+	// retries is the maximal number of retries of a write, zero disables the retries.
+	retries         int
+	initialInterval time.Duration
+	maxInterval     time.Duration
+}
+
+func (policy *retryPolicy) newBackoff() *wait.Backoff {
+	return &wait.Backoff{
+		Duration: policy.initialInterval,
+		Factor:   retryBackoffFactor,
+		Jitter:   retryBackoffJitter,
+		Steps:    policy.retries,
+		Cap:      policy.maxInterval,
+	}
+}
+
+// isTransientError returns whether a failed k8s API call may succeed if retried: conflicts, creations that raced with
+// another writer, throttling and server errors. other errors, e.g. validation or authorization errors, are permanent.
+func isTransientError(err error) bool {
+	if apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err) || apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) {
+		return true
+	}
+
+	var apiStatus apierrors.APIStatus
+	if errors.As(err, &apiStatus) {
+		return apiStatus.Status().Code >= http.StatusInternalServerError
+	}
+
+	return false
+}
+
+// applyWithRetry applies the aggregated status, retrying transient errors with an exponential backoff until the
+// retries run out or the context is cancelled.
+func (syncer *genericDBSyncer) applyWithRetry(ctx context.Context, object *SpecObject,
+	aggregatedStatus interface{}) (bool, error) {
+	backoff := syncer.retryPolicy.newBackoff()
+
+	for retries := 0; ; retries++ {
+		patched, err := syncer.dbSyncer.Apply(ctx, syncer.statusClient, object, aggregatedStatus)
+		if err == nil || !isTransientError(err) || retries >= syncer.retryPolicy.retries {
+			return patched, err
+		}
+
+		syncer.metrics.countWriteRetry()
+		syncer.log.Info("retrying a failed status update", "name", object.Name, "namespace", object.Namespace,
+			"error", err.Error())
+
+		timer := time.NewTimer(backoff.Step())
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false, err
+
+		case <-timer.C:
+		}
+	}
+}
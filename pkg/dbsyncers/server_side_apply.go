@@ -0,0 +1,130 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// fieldManager is the field manager of the fields written by the DB syncers.
+const fieldManager = "hub-of-hubs-status-sync"
+
+// serverSideApplyClient writes the patches of the DBSyncers by server-side apply. the patched object is the desired
+// state: its status when the status is patched, else its content and its labels, annotations and owner references.
+// conflicts with other field managers are returned as errors, unless the ownership is forced.
+type serverSideApplyClient struct {
+	client.Client
+	forceOwnership bool
+}
+
+func newServerSideApplyClient(k8sClient client.Client, forceOwnership bool) *serverSideApplyClient {
+	return &serverSideApplyClient{Client: k8sClient, forceOwnership: forceOwnership}
+}
+
+// Create creates the object with the field manager, so that its fields are owned by the following applies.
+func (k8sClient *serverSideApplyClient) Create(ctx context.Context, object client.Object,
+	opts ...client.CreateOption) error {
+	if err := k8sClient.Client.Create(ctx, object, append(opts, client.FieldOwner(fieldManager))...); err != nil {
+		return fmt.Errorf("failed to create object - %w", err)
+	}
+
+	return nil
+}
+
+// Patch applies the object instead of the patch.
+func (k8sClient *serverSideApplyClient) Patch(ctx context.Context, object client.Object, _ client.Patch,
+	opts ...client.PatchOption) error {
+	applyObject, err := k8sClient.newApplyObject(object, false)
+	if err != nil {
+		return err
+	}
+
+	if err := k8sClient.Client.Patch(ctx, applyObject, client.Apply, k8sClient.applyOptions(opts)...); err != nil {
+		return fmt.Errorf("failed to apply object - %w", err)
+	}
+
+	return nil
+}
+
+// Status returns a StatusWriter that applies the status of the objects instead of the patches.
+func (k8sClient *serverSideApplyClient) Status() client.StatusWriter {
+	return &serverSideApplyStatusWriter{StatusWriter: k8sClient.Client.Status(), k8sClient: k8sClient}
+}
+
+func (k8sClient *serverSideApplyClient) applyOptions(opts []client.PatchOption) []client.PatchOption {
+	opts = append(opts, client.FieldOwner(fieldManager))
+
+	if k8sClient.forceOwnership {
+		opts = append(opts, client.ForceOwnership)
+	}
+
+	return opts
+}
+
+// newApplyObject returns the fields of the object owned by the DB syncers, with the identity of the object.
+func (k8sClient *serverSideApplyClient) newApplyObject(object client.Object,
+	statusOnly bool) (*unstructured.Unstructured, error) {
+	gvk, err := apiutil.GVKForObject(object, k8sClient.Scheme())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get kind of object {name=%s, namespace=%s} - %w", object.GetName(),
+			object.GetNamespace(), err)
+	}
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert object {name=%s, namespace=%s} - %w", object.GetName(),
+			object.GetNamespace(), err)
+	}
+
+	applyObject := &unstructured.Unstructured{Object: map[string]interface{}{}}
+
+	if statusOnly {
+		if status, found := content["status"]; found {
+			applyObject.Object["status"] = status
+		}
+	} else {
+		for field, value := range content {
+			if field != "metadata" && field != "status" {
+				applyObject.Object[field] = value
+			}
+		}
+
+		applyObject.SetLabels(object.GetLabels())
+		applyObject.SetAnnotations(object.GetAnnotations())
+		applyObject.SetOwnerReferences(object.GetOwnerReferences())
+	}
+
+	applyObject.SetGroupVersionKind(gvk)
+	applyObject.SetName(object.GetName())
+	applyObject.SetNamespace(object.GetNamespace())
+
+	return applyObject, nil
+}
+
+// serverSideApplyStatusWriter applies the status of the objects instead of the patches.
+type serverSideApplyStatusWriter struct {
+	client.StatusWriter
+	k8sClient *serverSideApplyClient
+}
+
+func (statusWriter *serverSideApplyStatusWriter) Patch(ctx context.Context, object client.Object, _ client.Patch,
+	opts ...client.PatchOption) error {
+	applyObject, err := statusWriter.k8sClient.newApplyObject(object, true)
+	if err != nil {
+		return err
+	}
+
+	err = statusWriter.StatusWriter.Patch(ctx, applyObject, client.Apply, statusWriter.k8sClient.applyOptions(opts)...)
+	if err != nil {
+		return fmt.Errorf("failed to apply status - %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// StaleLeafHubPolicy is the way the statuses reported by stale leaf hubs are aggregated.
+type StaleLeafHubPolicy string
+
+const (
+	// StaleLeafHubPolicyDrop drops the statuses reported by stale leaf hubs from the aggregated status.
+	StaleLeafHubPolicyDrop StaleLeafHubPolicy = "drop"
+	// StaleLeafHubPolicyMark keeps the statuses reported by stale leaf hubs, marked as stale by the DBSyncers that
+	// implement StaleStatusMarker.
+	StaleLeafHubPolicyMark StaleLeafHubPolicy = "mark"
+)
+
+const (
+	leafHubHeartbeatsTableName = "leaf_hub_heartbeats"
+	// staleLeafHubsAnnotation lists the stale leaf hubs that reported the status of a spec object.
+	staleLeafHubsAnnotation = "hub-of-hubs.open-cluster-management.io/stale-leaf-hubs"
+	// staleLeafHubReason is the reason of the placement decisions and the message of the subscription packages
+	// reported by stale leaf hubs.
+	staleLeafHubReason = "StaleLeafHub"
+)
+
+// StaleStatusMarker is implemented by the DBSyncers that can mark a status reported by a stale leaf hub.
+type StaleStatusMarker interface {
+	// MarkStale returns a copy of a status reported by a stale leaf hub, with its clusters marked as unknown.
+	MarkStale(leafHubStatus interface{}) interface{}
+}
+
+// StaleStatusClearer is implemented by the DBSyncers whose Aggregate returns nil without statuses, so that the status
+// of an object whose statuses were all reported by stale leaf hubs and dropped is cleared instead of kept as is.
+type StaleStatusClearer interface {
+	// ClearStale returns a copy of a status reported by a stale leaf hub without its clusters.
+	ClearStale(leafHubStatus interface{}) interface{}
+}
+
+// handleStaleLeafHubStatuses drops or marks the statuses reported by stale leaf hubs, by the policy. returns the
+// statuses to aggregate and the sorted names of the stale leaf hubs that reported them. when all the statuses are
+// dropped, the statuses to aggregate are the cleared statuses of the DBSyncers that implement StaleStatusClearer.
+func handleStaleLeafHubStatuses(dbSyncer DBSyncer, policy StaleLeafHubPolicy, staleLeafHubs map[string]struct{},
+	leafHubStatuses []*LeafHubStatus) ([]*LeafHubStatus, []string) {
+	if len(staleLeafHubs) == 0 {
+		return leafHubStatuses, nil
+	}
+
+	marker, canMark := dbSyncer.(StaleStatusMarker)
+	handledStatuses := make([]*LeafHubStatus, 0, len(leafHubStatuses))
+	staleLeafHubNames := map[string]struct{}{}
+
+	var droppedStatuses []*LeafHubStatus
+
+	for _, leafHubStatus := range leafHubStatuses {
+		if _, stale := staleLeafHubs[leafHubStatus.LeafHubName]; !stale {
+			handledStatuses = append(handledStatuses, leafHubStatus)
+			continue
+		}
+
+		staleLeafHubNames[leafHubStatus.LeafHubName] = struct{}{}
+
+		switch {
+		case policy == StaleLeafHubPolicyDrop:
+			droppedStatuses = append(droppedStatuses, leafHubStatus)
+		case canMark:
+			handledStatuses = append(handledStatuses, &LeafHubStatus{
+				LeafHubName: leafHubStatus.LeafHubName,
+				Status:      marker.MarkStale(leafHubStatus.Status),
+			})
+		default: // the status is kept as is, the stale leaf hubs are still listed in the annotation
+			handledStatuses = append(handledStatuses, leafHubStatus)
+		}
+	}
+
+	if clearer, canClear := dbSyncer.(StaleStatusClearer); canClear && len(handledStatuses) == 0 {
+		for _, droppedStatus := range droppedStatuses {
+			handledStatuses = append(handledStatuses, &LeafHubStatus{
+				LeafHubName: droppedStatus.LeafHubName,
+				Status:      clearer.ClearStale(droppedStatus.Status),
+			})
+		}
+	}
+
+	sortedNames := make([]string, 0, len(staleLeafHubNames))
+	for staleLeafHubName := range staleLeafHubNames {
+		sortedNames = append(sortedNames, staleLeafHubName)
+	}
+
+	sort.Strings(sortedNames)
+
+	return handledStatuses, sortedNames
+}
+
+// updateStaleLeafHubsAnnotation patches the stale leaf hubs annotation of the object if it differs from the one of the
+// deployed object, so that the annotations written before a restart are kept up to date as well. the deployed object
+// is read by k8sReader, e.g. the API reader of the manager so that no informer is started for its kind.
+func updateStaleLeafHubsAnnotation(ctx context.Context, k8sReader client.Reader, k8sClient client.Client,
+	object client.Object, staleLeafHubNames []string) error {
+	annotation := strings.Join(staleLeafHubNames, ",")
+
+	deployedObject := &metav1.PartialObjectMetadata{}
+	deployedObject.SetGroupVersionKind(object.GetObjectKind().GroupVersionKind())
+
+	if err := k8sReader.Get(ctx, client.ObjectKeyFromObject(object), deployedObject); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+
+		return newAPIError(operationGet, fmt.Errorf("failed to get stale leaf hubs annotation {name=%s, namespace=%s} - %w",
+			object.GetName(), object.GetNamespace(), err))
+	}
+
+	if deployedObject.GetAnnotations()[staleLeafHubsAnnotation] == annotation {
+		return nil
+	}
+
+	var annotationValue interface{} // nil removes the annotation
+	if annotation != "" {
+		annotationValue = annotation
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{staleLeafHubsAnnotation: annotationValue},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal stale leaf hubs annotation patch - %w", err)
+	}
+
+	err = k8sClient.Patch(ctx, object, client.RawPatch(types.MergePatchType, patch))
+	if err != nil && !errors.IsNotFound(err) {
+		return newAPIError(operationPatch, fmt.Errorf("failed to annotate stale leaf hubs {name=%s, namespace=%s} - %w",
+			object.GetName(), object.GetNamespace(), err))
+	}
+
+	return nil
+}
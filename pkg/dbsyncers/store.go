@@ -0,0 +1,113 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// statusChangesOverlap is how far below the watermark the changed status rows are read again, since a transaction
+// that started earlier may commit its rows after those of a later transaction were read.
+const statusChangesOverlap = 5 * time.Second
+
+// StatusColumns selects the columns of the status rows returned by StatusStore.StatusRows.
+type StatusColumns string
+
+const (
+	// StatusColumnsPayload selects the leaf hub and the JSON payload of the status rows.
+	StatusColumnsPayload StatusColumns = "payload"
+	// StatusColumnsCompliance selects the leaf hub, the cluster and the compliance of the rows of the compliance
+	// status table.
+	StatusColumnsCompliance StatusColumns = "compliance"
+)
+
+// StatusRow is a row of a status table, with the columns selected by StatusColumns.
+type StatusRow struct {
+	// Key is the key of the spec object the row belongs to, see DBSyncer.StatusTable.
+	Key         string
+	LeafHubName string
+	Payload     json.RawMessage
+	ClusterName string
+	Compliance  string
+}
+
+// SpecStore reads the spec tables.
+type SpecStore interface {
+	// SpecObjects returns the non-deleted objects of a spec table, keyed by keyExpression. if keys is not nil, only
+	// the objects whose key is one of the keys are returned.
+	SpecObjects(ctx context.Context, tableName string, keyExpression string, keys []string) ([]*SpecObject, error)
+}
+
+// StatusStore reads the status tables.
+type StatusStore interface {
+	// StatusRows returns the rows of a status table whose keyExpression evaluates to one of the keys, ordered by the
+	// key, the leaf hub and the cluster.
+	StatusRows(ctx context.Context, tableName string, keyExpression string, columns StatusColumns,
+		keys []string) ([]*StatusRow, error)
+	// ChangedStatusKeys returns the keys of the objects whose status rows were updated or deleted after since, and
+	// the latest update time of these rows. the rows updated shortly before since are returned again, since they may
+	// have been committed after it.
+	ChangedStatusKeys(ctx context.Context, tableName string, keyExpression string,
+		since time.Time) ([]string, time.Time, error)
+	// StatusWatermark returns the latest update time of the rows of a status table.
+	StatusWatermark(ctx context.Context, tableName string) (time.Time, error)
+	// LeafHubUpdateTimes returns the last update time of the status rows of each leaf hub, by the key of the spec
+	// object they belong to.
+	LeafHubUpdateTimes(ctx context.Context, tableName string, keyExpression string,
+		keys []string) (map[string]map[string]time.Time, error)
+	// StaleLeafHubs returns the names of the leaf hubs whose last heartbeat is older than the threshold. leaf hubs
+	// that never sent a heartbeat are not considered stale.
+	StaleLeafHubs(ctx context.Context, threshold time.Duration) (map[string]struct{}, error)
+}
+
+// Store is the database the DB syncers read from, see NewPostgreSQLStore and NewMemoryStore.
+type Store interface {
+	SpecStore
+	StatusStore
+	// Ping checks that the database is reachable.
+	Ping(ctx context.Context) error
+}
+
+// QuerySpecObjects returns the non-deleted objects of a spec table, keyed by keyExpression. if keys is not nil, only
+// the objects whose key is one of the keys are returned.
+func QuerySpecObjects(ctx context.Context, specStore SpecStore, tableName string, keyExpression string,
+	keys []string) ([]*SpecObject, error) {
+	objects, err := specStore.SpecObjects(ctx, tableName, keyExpression, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get spec objects of %s - %w", tableName, err)
+	}
+
+	return objects, nil
+}
+
+// FetchStatusPayloads returns the payloads of the rows of a status table that belong to the given spec objects, with
+// the leaf hubs that reported them, by the object key. each payload is decoded into a new object returned by
+// newPayload, a payload that can not be decoded is returned as a LeafHubStatus with Err set, so that the other rows
+// of the page are still synced.
+func FetchStatusPayloads(ctx context.Context, statusStore StatusStore, tableName string, keyExpression string,
+	objects []*SpecObject, newPayload func() interface{}) (map[string][]*LeafHubStatus, error) {
+	rows, err := statusStore.StatusRows(ctx, tableName, keyExpression, StatusColumnsPayload,
+		specObjectKeys(objects))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status rows of %s - %w", tableName, err)
+	}
+
+	payloads := map[string][]*LeafHubStatus{}
+
+	for _, row := range rows {
+		leafHubStatus := &LeafHubStatus{LeafHubName: row.LeafHubName, Status: newPayload()}
+
+		if err := json.Unmarshal(row.Payload, leafHubStatus.Status); err != nil {
+			leafHubStatus.Status = nil
+			leafHubStatus.Err = fmt.Errorf("failed to decode payload of status.%s - %w", tableName, err)
+		}
+
+		payloads[row.Key] = append(payloads[row.Key], leafHubStatus)
+	}
+
+	return payloads, nil
+}
@@ -7,125 +7,227 @@ import (
 	"context"
 	"fmt"
 	"strconv"
-	"time"
 
-	"github.com/go-logr/logr"
-	"github.com/jackc/pgx/v4/pgxpool"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	appsv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
 	appsv1alpha1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1alpha1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func addSubscriptionReportDBSyncer(mgr ctrl.Manager, databaseConnectionPool *pgxpool.Pool,
-	syncInterval time.Duration) error {
-	err := mgr.Add(&genericDBSyncer{
-		syncInterval: syncInterval,
-		syncFunc: func(ctx context.Context) {
-			syncSubscriptionReports(ctx,
-				ctrl.Log.WithName("subscription-reports-db-syncer"),
-				databaseConnectionPool,
-				mgr.GetClient())
-		},
-	})
-	if err != nil {
-		return fmt.Errorf("failed to add subscription reports syncer to the manager: %w", err)
-	}
+// results of the clusters in a subscription-report.
+const (
+	subscriptionResultDeployed          appsv1alpha1.SubscriptionResult = "deployed"
+	subscriptionResultFailed            appsv1alpha1.SubscriptionResult = "failed"
+	subscriptionResultPropagationFailed appsv1alpha1.SubscriptionResult = "propagationFailed"
+)
+
+// subscriptionReportDBSyncer creates the subscription-reports of the subscriptions, aggregated from the leaf hubs.
+type subscriptionReportDBSyncer struct{}
+
+func newSubscriptionReportDBSyncer(ctrl.Manager) (DBSyncer, error) {
+	return &subscriptionReportDBSyncer{}, nil
+}
 
-	return nil
+func (syncer *subscriptionReportDBSyncer) SpecGroupVersionKind() schema.GroupVersionKind {
+	return schema.FromAPIVersionAndKind(appsv1APIGroup, subscriptionKind)
 }
 
-func syncSubscriptionReports(ctx context.Context, log logr.Logger, databaseConnectionPool *pgxpool.Pool,
-	k8sClient client.Client) {
-	log.Info("performing sync of subscription-report")
+// TargetGroupVersionKind returns the kind of the subscription-reports generated for the spec objects.
+func (syncer *subscriptionReportDBSyncer) TargetGroupVersionKind() schema.GroupVersionKind {
+	return schema.FromAPIVersionAndKind(appsv1alpha1APIGroup, subscriptionReportKind)
+}
+
+func (syncer *subscriptionReportDBSyncer) StatusTable() (string, string) {
+	return subscriptionReportsStatusTableName, StatusRowNamespacedNameKey
+}
+
+func (syncer *subscriptionReportDBSyncer) ListSpecObjects(ctx context.Context, specStore SpecStore,
+	keys []string) ([]*SpecObject, error) {
+	return QuerySpecObjects(ctx, specStore, subscriptionsSpecTableName, SpecRowNamespacedNameKey, keys)
+}
 
-	rows, err := databaseConnectionPool.Query(ctx,
-		fmt.Sprintf(`SELECT id, payload->'metadata'->>'name', payload->'metadata'->>'namespace' 
-		FROM spec.%s WHERE deleted = FALSE`, subscriptionsSpecTableName))
+func (syncer *subscriptionReportDBSyncer) FetchStatuses(ctx context.Context, statusStore StatusStore,
+	objects []*SpecObject) (map[string][]*LeafHubStatus, error) {
+	leafHubSubscriptionReports, err := FetchStatusPayloads(ctx, statusStore,
+		subscriptionReportsStatusTableName, StatusRowNamespacedNameKey, objects,
+		func() interface{} { return &appsv1alpha1.SubscriptionReport{} })
 	if err != nil {
-		log.Error(err, "error in getting subscriptions spec")
-		return
+		return nil, fmt.Errorf("error in getting subscription-reports from DB - %w", err)
 	}
 
-	for rows.Next() {
-		var uid, name, namespace string
+	return leafHubSubscriptionReports, nil
+}
+
+// Aggregate returns the aggregated SubscriptionReport, nil if no leaf hub reported a subscription-report of the
+// subscription.
+func (syncer *subscriptionReportDBSyncer) Aggregate(_ *SpecObject, leafHubStatuses []*LeafHubStatus) (interface{},
+	[]string) {
+	var subscriptionReport *appsv1alpha1.SubscriptionReport
+
+	for _, leafHubStatus := range leafHubStatuses {
+		leafHubSubscriptionReport, ok := leafHubStatus.Status.(*appsv1alpha1.SubscriptionReport)
+		if !ok {
+			continue
+		}
 
-		err := rows.Scan(&uid, &name, &namespace)
-		if err != nil {
-			log.Error(err, "error in select", "table", subscriptionsSpecTableName)
+		if subscriptionReport == nil { // clone the first subscription-report from DB and clean it
+			subscriptionReport = cleanSubscriptionReportObject(*leafHubSubscriptionReport)
 			continue
 		}
 
-		go handleSubscriptionReport(ctx, log, databaseConnectionPool, k8sClient, uid, name, namespace)
+		// update aggregated summary
+		updateSubscriptionReportSummary(&subscriptionReport.Summary, &leafHubSubscriptionReport.Summary)
+		// the clusters reported by more than one leaf hub were resolved before the aggregation, so merge
+		subscriptionReport.Results = append(subscriptionReport.Results, leafHubSubscriptionReport.Results...)
+	}
+
+	if subscriptionReport == nil { // no status resources found in DB
+		return nil, nil
+	}
+
+	// the duplicate clusters that were kept are reported by more than one result, count them once
+	for _, result := range duplicateResults(subscriptionReport.Results) {
+		uncountSubscriptionReportResult(&subscriptionReport.Summary, result)
 	}
+
+	return subscriptionReport, nil
 }
 
-func handleSubscriptionReport(ctx context.Context, log logr.Logger, databaseConnectionPool *pgxpool.Pool,
-	k8sClient client.Client, specSubscriptionUID string, subscriptionName string, subscriptionNamespace string) {
-	log.Info("handling a subscription", "name", subscriptionName, "namespace", subscriptionNamespace)
+// ClusterNames returns the clusters of the results of the SubscriptionReport.
+func (syncer *subscriptionReportDBSyncer) ClusterNames(leafHubStatus interface{}) []string {
+	subscriptionReport, ok := leafHubStatus.(*appsv1alpha1.SubscriptionReport)
+	if !ok {
+		return nil
+	}
 
-	subscriptionReport, err := getAggregatedSubscriptionReport(ctx, databaseConnectionPool, subscriptionName,
-		subscriptionNamespace)
-	if err != nil {
-		log.Error(err, "failed to get subscription-report", "name", subscriptionName,
-			"namespace", subscriptionNamespace)
+	clusterNames := make([]string, 0, len(subscriptionReport.Results))
 
-		return
+	for _, result := range subscriptionReport.Results {
+		clusterNames = append(clusterNames, result.Source)
 	}
 
-	if subscriptionReport == nil { // no status resources found in DB
-		return
+	return clusterNames
+}
+
+// RewriteClusters returns a copy of the SubscriptionReport with the clusters of its results renamed or dropped, the
+// summary no longer counts the dropped results. returns nil if all the results are dropped.
+func (syncer *subscriptionReportDBSyncer) RewriteClusters(leafHubStatus interface{},
+	rewrite func(clusterName string) (string, bool)) interface{} {
+	subscriptionReport, ok := leafHubStatus.(*appsv1alpha1.SubscriptionReport)
+	if !ok {
+		return leafHubStatus
 	}
 
-	// set owner-reference so that the subscription-report is deleted when the subscription is
-	setOwnerReference(subscriptionReport, createOwnerReference(appsv1APIGroup, subscriptionKind, subscriptionName,
-		specSubscriptionUID))
+	rewrittenSubscriptionReport := subscriptionReport.DeepCopy()
+	rewrittenSubscriptionReport.Results = nil
 
-	if err := updateSubscriptionReport(ctx, k8sClient, subscriptionReport); err != nil {
-		log.Error(err, "failed to update subscription-report status")
+	for _, result := range subscriptionReport.Results {
+		clusterName, keep := rewrite(result.Source)
+		if !keep {
+			uncountSubscriptionReportResult(&rewrittenSubscriptionReport.Summary, result)
+			continue
+		}
+
+		result := *result
+		result.Source = clusterName
+		rewrittenSubscriptionReport.Results = append(rewrittenSubscriptionReport.Results, &result)
 	}
+
+	if len(subscriptionReport.Results) > 0 && len(rewrittenSubscriptionReport.Results) == 0 {
+		return nil
+	}
+
+	return rewrittenSubscriptionReport
 }
 
-// returns aggregated SubscriptionReport and error.
-func getAggregatedSubscriptionReport(ctx context.Context, databaseConnectionPool *pgxpool.Pool,
-	subscriptionName string, subscriptionNamespace string) (*appsv1alpha1.SubscriptionReport, error) {
-	rows, err := databaseConnectionPool.Query(ctx,
-		fmt.Sprintf(`SELECT payload FROM status.%s
-			WHERE payload->'metadata'->>'name'=$1 AND payload->'metadata'->>'namespace'=$2`,
-			subscriptionReportsStatusTableName), subscriptionName, subscriptionNamespace)
-	if err != nil {
-		return nil, fmt.Errorf("error in getting subscription-report statuses from DB - %w", err)
+// MarkStale returns a copy of the SubscriptionReport with the results of its clusters unknown, the clusters are still
+// counted but not their results.
+func (syncer *subscriptionReportDBSyncer) MarkStale(leafHubStatus interface{}) interface{} {
+	subscriptionReport, ok := leafHubStatus.(*appsv1alpha1.SubscriptionReport)
+	if !ok {
+		return leafHubStatus
 	}
 
-	defer rows.Close()
+	staleSubscriptionReport := subscriptionReport.DeepCopy()
+	for _, result := range staleSubscriptionReport.Results {
+		uncountSubscriptionReportOutcome(&staleSubscriptionReport.Summary, result)
+		result.Result = ""
+	}
 
-	var subscriptionReport *appsv1alpha1.SubscriptionReport
+	return staleSubscriptionReport
+}
 
-	for rows.Next() {
-		var leafHubSubscriptionReport appsv1alpha1.SubscriptionReport
+// ClearStale returns a copy of the SubscriptionReport without results.
+func (syncer *subscriptionReportDBSyncer) ClearStale(leafHubStatus interface{}) interface{} {
+	subscriptionReport, ok := leafHubStatus.(*appsv1alpha1.SubscriptionReport)
+	if !ok {
+		return leafHubStatus
+	}
 
-		if err := rows.Scan(&leafHubSubscriptionReport); err != nil {
-			return nil, fmt.Errorf("error getting subscription reports from DB - %w", err)
-		}
+	clearedSubscriptionReport := subscriptionReport.DeepCopy()
+	clearedSubscriptionReport.Results = nil
+	clearedSubscriptionReport.Summary = appsv1alpha1.SubscriptionReportSummary{
+		Deployed:          "0",
+		InProgress:        "0",
+		Failed:            "0",
+		PropagationFailed: "0",
+		Clusters:          "0",
+	}
 
-		// if not updated yet, clone a report from DB and clean it
-		if subscriptionReport == nil {
-			subscriptionReport = cleanSubscriptionReportObject(leafHubSubscriptionReport)
-			continue
-		}
+	return clearedSubscriptionReport
+}
 
-		// update aggregated summary
-		updateSubscriptionReportSummary(&subscriptionReport.Summary, &leafHubSubscriptionReport.Summary)
-		// update results - assuming that MC names are unique across leaf-hubs, we only need to merge
-		subscriptionReport.Results = append(subscriptionReport.Results, leafHubSubscriptionReport.Results...)
+func (syncer *subscriptionReportDBSyncer) Apply(ctx context.Context, k8sClient client.Client, object *SpecObject,
+	aggregatedStatus interface{}) (bool, error) {
+	subscriptionReport, ok := aggregatedStatus.(*appsv1alpha1.SubscriptionReport)
+	if !ok {
+		return false, fmt.Errorf("%w: %T", errUnexpectedStatusType, aggregatedStatus)
 	}
 
-	return subscriptionReport, nil
+	// set owner-reference so that the subscription-report is deleted when the subscription is
+	setOwnerReference(subscriptionReport, createOwnerReference(appsv1APIGroup, subscriptionKind, object.Name,
+		object.UID))
+
+	return updateSubscriptionReport(ctx, k8sClient, subscriptionReport)
+}
+
+// ListSyncedObjects returns the subscription-reports created by this syncer, by their namespaced name.
+func (syncer *subscriptionReportDBSyncer) ListSyncedObjects(ctx context.Context,
+	k8sReader client.Reader) ([]*SyncedObject, error) {
+	subscriptionReports := &appsv1alpha1.SubscriptionReportList{}
+
+	if err := k8sReader.List(ctx, subscriptionReports,
+		client.MatchingLabels{statusSyncLabelKey: statusSyncLabelValue}); err != nil {
+		return nil, newAPIError(operationList, fmt.Errorf("failed to list subscription-reports - %w", err))
+	}
+
+	syncedObjects := make([]*SyncedObject, 0, len(subscriptionReports.Items))
+
+	for i := range subscriptionReports.Items {
+		subscriptionReport := &subscriptionReports.Items[i]
+
+		syncedObjects = append(syncedObjects, &SyncedObject{
+			Key:      namespacedNameKey(subscriptionReport.Namespace, subscriptionReport.Name),
+			Object:   subscriptionReport,
+			OwnerUID: controllerUID(subscriptionReport),
+		})
+	}
+
+	return syncedObjects, nil
+}
+
+// Collect deletes the subscription-report.
+func (syncer *subscriptionReportDBSyncer) Collect(ctx context.Context, k8sClient client.Client,
+	object client.Object) error {
+	return deleteK8sResource(ctx, k8sClient, object)
 }
 
+// returns whether the subscription-report was created or patched, it is not patched if the status did not change.
 func updateSubscriptionReport(ctx context.Context, k8sClient client.Client,
-	aggregatedSubscriptionReport *appsv1alpha1.SubscriptionReport) error {
+	aggregatedSubscriptionReport *appsv1alpha1.SubscriptionReport) (bool, error) {
 	deployedSubscriptionReport := &appsv1alpha1.SubscriptionReport{}
 
 	err := k8sClient.Get(ctx, client.ObjectKey{
@@ -135,15 +237,17 @@ func updateSubscriptionReport(ctx context.Context, k8sClient client.Client,
 	if err != nil {
 		if errors.IsNotFound(err) { // create CR
 			if err := createK8sResource(ctx, k8sClient, aggregatedSubscriptionReport); err != nil {
-				return fmt.Errorf("failed to create subscription-report {name=%s, namespace=%s} - %w",
-					aggregatedSubscriptionReport.Name, aggregatedSubscriptionReport.Namespace, err)
+				return false, newAPIError(operationCreate,
+					fmt.Errorf("failed to create subscription-report {name=%s, namespace=%s} - %w",
+						aggregatedSubscriptionReport.Name, aggregatedSubscriptionReport.Namespace, err))
 			}
 
-			return nil
+			return true, nil
 		}
 
-		return fmt.Errorf("failed to get subscription-report {name=%s, namespace=%s} - %w",
-			aggregatedSubscriptionReport.Name, aggregatedSubscriptionReport.Namespace, err)
+		return false, newAPIError(operationGet,
+			fmt.Errorf("failed to get subscription-report {name=%s, namespace=%s} - %w",
+				aggregatedSubscriptionReport.Name, aggregatedSubscriptionReport.Namespace, err))
 	}
 
 	// if object exists, clone and update
@@ -152,13 +256,19 @@ func updateSubscriptionReport(ctx context.Context, k8sClient client.Client,
 	deployedSubscriptionReport.Summary = aggregatedSubscriptionReport.Summary
 	deployedSubscriptionReport.Results = aggregatedSubscriptionReport.Results
 
+	if equality.Semantic.DeepEqual(originalSubscriptionReport.Summary, deployedSubscriptionReport.Summary) &&
+		equality.Semantic.DeepEqual(originalSubscriptionReport.Results, deployedSubscriptionReport.Results) {
+		return false, nil
+	}
+
 	err = k8sClient.Patch(ctx, deployedSubscriptionReport, client.MergeFrom(originalSubscriptionReport))
 	if err != nil {
-		return fmt.Errorf("failed to update subscription-report CR (name=%s, namespace=%s): %w",
-			deployedSubscriptionReport.Name, deployedSubscriptionReport.Namespace, err)
+		return false, newAPIError(operationPatch,
+			fmt.Errorf("failed to update subscription-report CR (name=%s, namespace=%s): %w",
+				deployedSubscriptionReport.Name, deployedSubscriptionReport.Namespace, err))
 	}
 
-	return nil
+	return true, nil
 }
 
 func updateSubscriptionReportSummary(aggregatedSummary *appsv1alpha1.SubscriptionReportSummary,
@@ -174,6 +284,45 @@ func updateSubscriptionReportSummary(aggregatedSummary *appsv1alpha1.Subscriptio
 	aggregatedSummary.Clusters = add(aggregatedSummary.Clusters, reportSummary.Clusters)
 }
 
+// uncountSubscriptionReportResult removes a result from the counts of the summary.
+func uncountSubscriptionReportResult(summary *appsv1alpha1.SubscriptionReportSummary,
+	result *appsv1alpha1.SubscriptionReportResult) {
+	uncountSubscriptionReportOutcome(summary, result)
+
+	summary.Clusters = subtract(summary.Clusters, 1)
+}
+
+// uncountSubscriptionReportOutcome removes the outcome of a result from the summary, its cluster is still counted.
+func uncountSubscriptionReportOutcome(summary *appsv1alpha1.SubscriptionReportSummary,
+	result *appsv1alpha1.SubscriptionReportResult) {
+	switch result.Result {
+	case subscriptionResultDeployed:
+		summary.Deployed = subtract(summary.Deployed, 1)
+	case subscriptionResultFailed:
+		summary.Failed = subtract(summary.Failed, 1)
+	case subscriptionResultPropagationFailed:
+		summary.PropagationFailed = subtract(summary.PropagationFailed, 1)
+	}
+}
+
+// duplicateResults returns the results whose cluster is reported by a previous result.
+func duplicateResults(results []*appsv1alpha1.SubscriptionReportResult) []*appsv1alpha1.SubscriptionReportResult {
+	clusterNames := make(map[string]struct{}, len(results))
+
+	var duplicates []*appsv1alpha1.SubscriptionReportResult
+
+	for _, result := range results {
+		if _, found := clusterNames[result.Source]; found {
+			duplicates = append(duplicates, result)
+			continue
+		}
+
+		clusterNames[result.Source] = struct{}{}
+	}
+
+	return duplicates
+}
+
 func cleanSubscriptionReportObject(subscriptionReport appsv1alpha1.SubscriptionReport,
 ) *appsv1alpha1.SubscriptionReport {
 	clone := subscriptionReport.DeepCopy()
@@ -191,6 +340,15 @@ func add(number1 string, number2 string) string {
 	return strconv.Itoa(stringToInt(number1) + stringToInt(number2))
 }
 
+// subtract returns the number decreased by count, not below zero.
+func subtract(number string, count int) string {
+	if difference := stringToInt(number) - count; difference > 0 {
+		return strconv.Itoa(difference)
+	}
+
+	return "0"
+}
+
 func stringToInt(numberString string) int {
 	if number, err := strconv.Atoi(numberString); err == nil {
 		return number
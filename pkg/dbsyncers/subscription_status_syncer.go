@@ -5,124 +5,249 @@ package dbsyncers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"time"
+	"sort"
 
-	"github.com/go-logr/logr"
-	"github.com/jackc/pgx/v4/pgxpool"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	appsv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
 	appsv1alpha1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1alpha1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func addSubscriptionStatusStatusDBSyncer(mgr ctrl.Manager, databaseConnectionPool *pgxpool.Pool,
-	syncInterval time.Duration) error {
-	err := mgr.Add(&genericDBSyncer{
-		syncInterval: syncInterval,
-		syncFunc: func(ctx context.Context) {
-			syncSubscriptionStatuses(ctx,
-				ctrl.Log.WithName("subscription-statuses-db-syncer"),
-				databaseConnectionPool,
-				mgr.GetClient())
-		},
-	})
-	if err != nil {
-		return fmt.Errorf("failed to add subscription statuses syncer to the manager: %w", err)
-	}
+const (
+	// packageLeafHubsAnnotation maps the packages of a subscription-status to the leaf hubs that reported them.
+	packageLeafHubsAnnotation = "hub-of-hubs.open-cluster-management.io/package-leaf-hubs"
+	// subscriptionStatusClusterLabel names the cluster the packages of a subscription-status are deployed to.
+	subscriptionStatusClusterLabel = "apps.open-cluster-management.io/cluster"
+)
+
+// subscriptionStatusDBSyncer creates the subscription-statuses of the subscriptions, aggregated from the leaf hubs.
+type subscriptionStatusDBSyncer struct{}
+
+func newSubscriptionStatusDBSyncer(ctrl.Manager) (DBSyncer, error) {
+	return &subscriptionStatusDBSyncer{}, nil
+}
 
-	return nil
+func (syncer *subscriptionStatusDBSyncer) SpecGroupVersionKind() schema.GroupVersionKind {
+	return schema.FromAPIVersionAndKind(appsv1APIGroup, subscriptionKind)
 }
 
-func syncSubscriptionStatuses(ctx context.Context, log logr.Logger, databaseConnectionPool *pgxpool.Pool,
-	k8sClient client.Client) {
-	log.Info("performing sync of subscription-status")
+// TargetGroupVersionKind returns the kind of the subscription-statuses generated for the spec objects.
+func (syncer *subscriptionStatusDBSyncer) TargetGroupVersionKind() schema.GroupVersionKind {
+	return schema.FromAPIVersionAndKind(appsv1alpha1APIGroup, subscriptionStatusKind)
+}
+
+func (syncer *subscriptionStatusDBSyncer) StatusTable() (string, string) {
+	return subscriptionStatusesTableName, StatusRowNamespacedNameKey
+}
+
+func (syncer *subscriptionStatusDBSyncer) ListSpecObjects(ctx context.Context, specStore SpecStore,
+	keys []string) ([]*SpecObject, error) {
+	return QuerySpecObjects(ctx, specStore, subscriptionsSpecTableName, SpecRowNamespacedNameKey, keys)
+}
 
-	rows, err := databaseConnectionPool.Query(ctx,
-		fmt.Sprintf(`SELECT id, payload->'metadata'->>'name', payload->'metadata'->>'namespace' 
-		FROM spec.%s WHERE deleted = FALSE`, subscriptionsSpecTableName))
+func (syncer *subscriptionStatusDBSyncer) FetchStatuses(ctx context.Context, statusStore StatusStore,
+	objects []*SpecObject) (map[string][]*LeafHubStatus, error) {
+	leafHubSubscriptionStatuses, err := FetchStatusPayloads(ctx, statusStore,
+		subscriptionStatusesTableName, StatusRowNamespacedNameKey, objects,
+		func() interface{} { return &appsv1alpha1.SubscriptionStatus{} })
 	if err != nil {
-		log.Error(err, "error in getting subscriptions spec")
-		return
+		return nil, fmt.Errorf("error in getting subscription-statuses from DB - %w", err)
 	}
 
-	for rows.Next() {
-		var uid, name, namespace string
+	return leafHubSubscriptionStatuses, nil
+}
+
+// Aggregate returns the aggregated SubscriptionStatus, nil if no leaf hub reported a subscription-status of the
+// subscription.
+func (syncer *subscriptionStatusDBSyncer) Aggregate(_ *SpecObject, leafHubStatuses []*LeafHubStatus) (interface{},
+	[]string) {
+	var subscriptionStatus *appsv1alpha1.SubscriptionStatus
+
+	for _, leafHubStatus := range leafHubStatuses {
+		leafHubSubscriptionStatus, ok := leafHubStatus.Status.(*appsv1alpha1.SubscriptionStatus)
+		if !ok {
+			continue
+		}
 
-		err := rows.Scan(&uid, &name, &namespace)
-		if err != nil {
-			log.Error(err, "error in select", "table", subscriptionsSpecTableName)
+		if subscriptionStatus == nil { // clone the first subscription-status from DB and clean it
+			subscriptionStatus = cleanSubscriptionStatusObject(*leafHubSubscriptionStatus)
 			continue
 		}
 
-		go handleSubscriptionStatus(ctx, log, databaseConnectionPool, k8sClient, uid, name, namespace)
+		// the clusters reported by more than one leaf hub were resolved before the aggregation, so merge
+		subscriptionStatus.Statuses.SubscriptionStatus = append(subscriptionStatus.Statuses.SubscriptionStatus,
+			leafHubSubscriptionStatus.Statuses.SubscriptionStatus...)
+	}
+
+	if subscriptionStatus == nil { // no status resources found in DB
+		return nil, nil
 	}
+
+	return subscriptionStatus, nil
 }
 
-func handleSubscriptionStatus(ctx context.Context, log logr.Logger, databaseConnectionPool *pgxpool.Pool,
-	k8sClient client.Client, specSubscriptionUID string, subscriptionName string, subscriptionNamespace string) {
-	log.Info("handling a subscription", "name", subscriptionName, "namespace", subscriptionNamespace)
+// ClusterNames returns the cluster of the SubscriptionStatus, by its cluster label.
+func (syncer *subscriptionStatusDBSyncer) ClusterNames(leafHubStatus interface{}) []string {
+	subscriptionStatus, ok := leafHubStatus.(*appsv1alpha1.SubscriptionStatus)
+	if !ok {
+		return nil
+	}
 
-	subscriptionStatus, err := getAggregatedSubscriptionStatuses(ctx, databaseConnectionPool, subscriptionName,
-		subscriptionNamespace)
-	if err != nil {
-		log.Error(err, "failed to get aggregated subscription-status", "name", subscriptionName,
-			"namespace", subscriptionNamespace)
+	clusterName, found := subscriptionStatus.Labels[subscriptionStatusClusterLabel]
+	if !found || clusterName == "" {
+		return nil
+	}
 
+	return []string{clusterName}
+}
+
+// RewriteClusters returns a copy of the SubscriptionStatus with its cluster label renamed, or nil if its cluster is
+// dropped.
+func (syncer *subscriptionStatusDBSyncer) RewriteClusters(leafHubStatus interface{},
+	rewrite func(clusterName string) (string, bool)) interface{} {
+	subscriptionStatus, ok := leafHubStatus.(*appsv1alpha1.SubscriptionStatus)
+	if !ok {
+		return leafHubStatus
+	}
+
+	clusterName, found := subscriptionStatus.Labels[subscriptionStatusClusterLabel]
+	if !found || clusterName == "" {
+		return leafHubStatus
+	}
+
+	newClusterName, keep := rewrite(clusterName)
+	if !keep {
+		return nil
+	}
+
+	rewrittenSubscriptionStatus := subscriptionStatus.DeepCopy()
+	rewrittenSubscriptionStatus.Labels[subscriptionStatusClusterLabel] = newClusterName
+
+	return rewrittenSubscriptionStatus
+}
+
+// AnnotateLeafHubs annotates the aggregated SubscriptionStatus with the leaf hubs of each package, by kind, namespace
+// and name, since the packages do not name their clusters.
+func (syncer *subscriptionStatusDBSyncer) AnnotateLeafHubs(aggregatedStatus interface{},
+	leafHubStatuses []*LeafHubStatus) {
+	subscriptionStatus, ok := aggregatedStatus.(*appsv1alpha1.SubscriptionStatus)
+	if !ok {
 		return
 	}
 
-	if subscriptionStatus == nil { // no status resources found in DB
+	packageLeafHubs := map[string][]string{}
+
+	for _, leafHubStatus := range leafHubStatuses {
+		leafHubSubscriptionStatus, ok := leafHubStatus.Status.(*appsv1alpha1.SubscriptionStatus)
+		if !ok {
+			continue
+		}
+
+		for _, packageStatus := range leafHubSubscriptionStatus.Statuses.SubscriptionStatus {
+			packageKey := fmt.Sprintf("%s/%s/%s", packageStatus.Kind, packageStatus.Namespace, packageStatus.Name)
+			packageLeafHubs[packageKey] = append(packageLeafHubs[packageKey], leafHubStatus.LeafHubName)
+		}
+	}
+
+	for _, leafHubNames := range packageLeafHubs {
+		sort.Strings(leafHubNames)
+	}
+
+	annotation, err := json.Marshal(packageLeafHubs) // map keys are sorted
+	if err != nil {
 		return
 	}
 
-	// set owner-reference so that the subscription-status is deleted when the subscription is
-	setOwnerReference(subscriptionStatus, createOwnerReference(appsv1APIGroup, subscriptionKind, subscriptionName,
-		specSubscriptionUID))
+	if subscriptionStatus.Annotations == nil {
+		subscriptionStatus.Annotations = map[string]string{}
+	}
 
-	if err := updateSubscriptionStatus(ctx, k8sClient, subscriptionStatus); err != nil {
-		log.Error(err, "failed to update subscription-status status")
+	subscriptionStatus.Annotations[packageLeafHubsAnnotation] = string(annotation)
+}
+
+// MarkStale returns a copy of the SubscriptionStatus with the phase of its packages unknown and their message set to a
+// stale leaf hub.
+func (syncer *subscriptionStatusDBSyncer) MarkStale(leafHubStatus interface{}) interface{} {
+	subscriptionStatus, ok := leafHubStatus.(*appsv1alpha1.SubscriptionStatus)
+	if !ok {
+		return leafHubStatus
 	}
+
+	staleSubscriptionStatus := subscriptionStatus.DeepCopy()
+	for i := range staleSubscriptionStatus.Statuses.SubscriptionStatus {
+		staleSubscriptionStatus.Statuses.SubscriptionStatus[i].Phase = appsv1alpha1.PackageUnknown
+		staleSubscriptionStatus.Statuses.SubscriptionStatus[i].Message = staleLeafHubReason
+	}
+
+	return staleSubscriptionStatus
 }
 
-// returns aggregated SubscriptionStatus and error.
-func getAggregatedSubscriptionStatuses(ctx context.Context, databaseConnectionPool *pgxpool.Pool,
-	subscriptionName string, subscriptionNamespace string) (*appsv1alpha1.SubscriptionStatus, error) {
-	rows, err := databaseConnectionPool.Query(ctx,
-		fmt.Sprintf(`SELECT payload FROM status.%s
-			WHERE payload->'metadata'->>'name'=$1 AND payload->'metadata'->>'namespace'=$2`,
-			subscriptionStatusesTableName), subscriptionName, subscriptionNamespace)
-	if err != nil {
-		return nil, fmt.Errorf("error in getting subscription-statuses from DB - %w", err)
+// ClearStale returns a copy of the SubscriptionStatus without packages.
+func (syncer *subscriptionStatusDBSyncer) ClearStale(leafHubStatus interface{}) interface{} {
+	subscriptionStatus, ok := leafHubStatus.(*appsv1alpha1.SubscriptionStatus)
+	if !ok {
+		return leafHubStatus
 	}
 
-	defer rows.Close()
+	clearedSubscriptionStatus := subscriptionStatus.DeepCopy()
+	clearedSubscriptionStatus.Statuses.SubscriptionStatus = nil
 
-	var subscriptionStatus *appsv1alpha1.SubscriptionStatus
+	return clearedSubscriptionStatus
+}
+
+func (syncer *subscriptionStatusDBSyncer) Apply(ctx context.Context, k8sClient client.Client, object *SpecObject,
+	aggregatedStatus interface{}) (bool, error) {
+	subscriptionStatus, ok := aggregatedStatus.(*appsv1alpha1.SubscriptionStatus)
+	if !ok {
+		return false, fmt.Errorf("%w: %T", errUnexpectedStatusType, aggregatedStatus)
+	}
 
-	for rows.Next() {
-		var leafHubSubscriptionStatus appsv1alpha1.SubscriptionStatus
+	// set owner-reference so that the subscription-status is deleted when the subscription is
+	setOwnerReference(subscriptionStatus, createOwnerReference(appsv1APIGroup, subscriptionKind, object.Name,
+		object.UID))
 
-		if err := rows.Scan(&leafHubSubscriptionStatus); err != nil {
-			return nil, fmt.Errorf("error getting subscription-status from DB - %w", err)
-		}
+	return updateSubscriptionStatus(ctx, k8sClient, subscriptionStatus)
+}
 
-		if subscriptionStatus == nil {
-			subscriptionStatus = cleanSubscriptionStatusObject(leafHubSubscriptionStatus)
-			continue
-		}
+// ListSyncedObjects returns the subscription-statuses created by this syncer, by their namespaced name.
+func (syncer *subscriptionStatusDBSyncer) ListSyncedObjects(ctx context.Context,
+	k8sReader client.Reader) ([]*SyncedObject, error) {
+	subscriptionStatuses := &appsv1alpha1.SubscriptionStatusList{}
 
-		// assuming that cluster names are unique across the hubs, all we need to do is a complete merge
-		subscriptionStatus.Statuses.SubscriptionStatus = append(subscriptionStatus.Statuses.SubscriptionStatus,
-			leafHubSubscriptionStatus.Statuses.SubscriptionStatus...)
+	if err := k8sReader.List(ctx, subscriptionStatuses,
+		client.MatchingLabels{statusSyncLabelKey: statusSyncLabelValue}); err != nil {
+		return nil, newAPIError(operationList, fmt.Errorf("failed to list subscription-statuses - %w", err))
 	}
 
-	return subscriptionStatus, nil
+	syncedObjects := make([]*SyncedObject, 0, len(subscriptionStatuses.Items))
+
+	for i := range subscriptionStatuses.Items {
+		subscriptionStatus := &subscriptionStatuses.Items[i]
+
+		syncedObjects = append(syncedObjects, &SyncedObject{
+			Key:      namespacedNameKey(subscriptionStatus.Namespace, subscriptionStatus.Name),
+			Object:   subscriptionStatus,
+			OwnerUID: controllerUID(subscriptionStatus),
+		})
+	}
+
+	return syncedObjects, nil
 }
 
+// Collect deletes the subscription-status.
+func (syncer *subscriptionStatusDBSyncer) Collect(ctx context.Context, k8sClient client.Client,
+	object client.Object) error {
+	return deleteK8sResource(ctx, k8sClient, object)
+}
+
+// returns whether the subscription-status was created or patched, it is not patched if the status did not change.
 func updateSubscriptionStatus(ctx context.Context, k8sClient client.Client,
-	aggregatedSubscriptionStatus *appsv1alpha1.SubscriptionStatus) error {
+	aggregatedSubscriptionStatus *appsv1alpha1.SubscriptionStatus) (bool, error) {
 	deployedSubscriptionStatus := &appsv1alpha1.SubscriptionStatus{}
 
 	err := k8sClient.Get(ctx, client.ObjectKey{
@@ -132,15 +257,17 @@ func updateSubscriptionStatus(ctx context.Context, k8sClient client.Client,
 	if err != nil {
 		if errors.IsNotFound(err) { // create CR
 			if err := createK8sResource(ctx, k8sClient, aggregatedSubscriptionStatus); err != nil {
-				return fmt.Errorf("failed to create subscription-status {name=%s, namespace=%s} - %w",
-					aggregatedSubscriptionStatus.Name, aggregatedSubscriptionStatus.Namespace, err)
+				return false, newAPIError(operationCreate,
+					fmt.Errorf("failed to create subscription-status {name=%s, namespace=%s} - %w",
+						aggregatedSubscriptionStatus.Name, aggregatedSubscriptionStatus.Namespace, err))
 			}
 
-			return nil
+			return true, nil
 		}
 
-		return fmt.Errorf("failed to get subscription-status {name=%s, namespace=%s} - %w",
-			aggregatedSubscriptionStatus.Name, aggregatedSubscriptionStatus.Namespace, err)
+		return false, newAPIError(operationGet,
+			fmt.Errorf("failed to get subscription-status {name=%s, namespace=%s} - %w",
+				aggregatedSubscriptionStatus.Name, aggregatedSubscriptionStatus.Namespace, err))
 	}
 
 	// if object exists, clone and update
@@ -148,13 +275,29 @@ func updateSubscriptionStatus(ctx context.Context, k8sClient client.Client,
 
 	deployedSubscriptionStatus.Statuses = aggregatedSubscriptionStatus.Statuses
 
+	if packageLeafHubs, found := aggregatedSubscriptionStatus.Annotations[packageLeafHubsAnnotation]; found {
+		if deployedSubscriptionStatus.Annotations == nil {
+			deployedSubscriptionStatus.Annotations = map[string]string{}
+		}
+
+		deployedSubscriptionStatus.Annotations[packageLeafHubsAnnotation] = packageLeafHubs
+	} else {
+		delete(deployedSubscriptionStatus.Annotations, packageLeafHubsAnnotation)
+	}
+
+	if equality.Semantic.DeepEqual(originalSubscriptionStatus.Statuses, deployedSubscriptionStatus.Statuses) &&
+		equality.Semantic.DeepEqual(originalSubscriptionStatus.Annotations, deployedSubscriptionStatus.Annotations) {
+		return false, nil
+	}
+
 	err = k8sClient.Patch(ctx, deployedSubscriptionStatus, client.MergeFrom(originalSubscriptionStatus))
 	if err != nil && !errors.IsNotFound(err) {
-		return fmt.Errorf("failed to update subscription-status CR (name=%s, namespace=%s): %w",
-			deployedSubscriptionStatus.Name, deployedSubscriptionStatus.Namespace, err)
+		return false, newAPIError(operationPatch,
+			fmt.Errorf("failed to update subscription-status CR (name=%s, namespace=%s): %w",
+				deployedSubscriptionStatus.Name, deployedSubscriptionStatus.Namespace, err))
 	}
 
-	return nil
+	return true, nil
 }
 
 func cleanSubscriptionStatusObject(subscriptionStatus appsv1alpha1.SubscriptionStatus,
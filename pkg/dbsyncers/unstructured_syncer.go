@@ -0,0 +1,497 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// MergeStrategy is the way the values a field has in the leaf hubs are merged into the aggregated status.
+type MergeStrategy string
+
+const (
+	// MergeStrategyConcatenate concatenates list fields.
+	MergeStrategyConcatenate MergeStrategy = "concatenate"
+	// MergeStrategySum sums integer fields.
+	MergeStrategySum MergeStrategy = "sum"
+	// MergeStrategyWorstOf picks the worst value of string fields, by the order of the field configuration.
+	MergeStrategyWorstOf MergeStrategy = "worstOf"
+)
+
+const tableNamePattern = `^[a-z_][a-z0-9_]*$`
+
+// UnstructuredDBSyncersConfig is the content of the configuration file of the unstructured DBSyncers.
+type UnstructuredDBSyncersConfig struct {
+	Syncers []*UnstructuredDBSyncerConfig `json:"syncers"`
+}
+
+// UnstructuredDBSyncerConfig configures a DBSyncer of a kind this project does not know about. the spec and status
+// tables are expected to follow the layout of the built-in ones, with the namespaced name of the object as its key.
+type UnstructuredDBSyncerConfig struct {
+	// Name is the name of the DBSyncer, it must be unique among the registered DBSyncers.
+	Name string `json:"name"`
+	// APIVersion and Kind of the spec objects, their status is updated unless GeneratedObject is set.
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	// SpecTable is the name of the table in the spec schema.
+	SpecTable string `json:"specTable"`
+	// StatusTable is the name of the table in the status schema.
+	StatusTable string `json:"statusTable"`
+	// GeneratedObject makes the DBSyncer write the aggregated fields to an object with the name and namespace of the
+	// spec object and owned by it, as done for subscription-reports.
+	GeneratedObject *UnstructuredGeneratedObjectConfig `json:"generatedObject,omitempty"`
+	// Fields are the merged fields.
+	Fields []*UnstructuredFieldConfig `json:"fields"`
+}
+
+// UnstructuredGeneratedObjectConfig is the kind of the objects generated by an unstructured DBSyncer.
+type UnstructuredGeneratedObjectConfig struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+}
+
+// UnstructuredFieldConfig configures how a field is merged.
+type UnstructuredFieldConfig struct {
+	// Path is the dot-separated path of the field, for example status.decisions.
+	Path     string        `json:"path"`
+	Strategy MergeStrategy `json:"strategy"`
+	// Order lists the values of a worstOf field from the best to the worst, other values are ignored.
+	Order []string `json:"order,omitempty"`
+}
+
+// ReadUnstructuredDBSyncersConfigFile reads and validates a YAML configuration file of unstructured DBSyncers.
+func ReadUnstructuredDBSyncersConfigFile(path string) (*UnstructuredDBSyncersConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read unstructured DB syncers configuration file: %w", err)
+	}
+
+	config := &UnstructuredDBSyncersConfig{}
+
+	if err := yaml.UnmarshalStrict(content, config); err != nil {
+		return nil, fmt.Errorf("failed to parse unstructured DB syncers configuration file %s: %w", path, err)
+	}
+
+	for _, syncerConfig := range config.Syncers {
+		if err := syncerConfig.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid unstructured DB syncers configuration file %s: %w", path, err)
+		}
+	}
+
+	return config, nil
+}
+
+// Validate returns an error if the configuration is incomplete or inconsistent.
+func (config *UnstructuredDBSyncerConfig) Validate() error {
+	if config.Name == "" {
+		return fmt.Errorf("%w: DB syncer must have a name", errInvalidConfiguration)
+	}
+
+	if config.APIVersion == "" || config.Kind == "" {
+		return fmt.Errorf("%w: DB syncer %s must have an apiVersion and a kind", errInvalidConfiguration,
+			config.Name)
+	}
+
+	if config.GeneratedObject != nil && (config.GeneratedObject.APIVersion == "" ||
+		config.GeneratedObject.Kind == "") {
+		return fmt.Errorf("%w: generated object of DB syncer %s must have an apiVersion and a kind",
+			errInvalidConfiguration, config.Name)
+	}
+
+	tableNameRegexp := regexp.MustCompile(tableNamePattern)
+
+	for _, tableName := range []string{config.SpecTable, config.StatusTable} {
+		if !tableNameRegexp.MatchString(tableName) {
+			return fmt.Errorf("%w: table name %q of DB syncer %s must match %s", errInvalidConfiguration,
+				tableName, config.Name, tableNamePattern)
+		}
+	}
+
+	if len(config.Fields) == 0 {
+		return fmt.Errorf("%w: DB syncer %s must have fields", errInvalidConfiguration, config.Name)
+	}
+
+	for _, field := range config.Fields {
+		if err := config.validateField(field); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (config *UnstructuredDBSyncerConfig) validateField(field *UnstructuredFieldConfig) error {
+	if field.Path == "" || strings.HasPrefix(field.Path, "metadata.") {
+		return fmt.Errorf("%w: field %q of DB syncer %s is not a valid path", errInvalidConfiguration,
+			field.Path, config.Name)
+	}
+
+	// the status of a spec object is written through its status subresource, which ignores other fields
+	if config.GeneratedObject == nil && !strings.HasPrefix(field.Path, "status.") {
+		return fmt.Errorf("%w: field %q of DB syncer %s must be under status", errInvalidConfiguration,
+			field.Path, config.Name)
+	}
+
+	switch field.Strategy {
+	case MergeStrategyConcatenate, MergeStrategySum:
+	case MergeStrategyWorstOf:
+		if len(field.Order) == 0 {
+			return fmt.Errorf("%w: field %s of DB syncer %s must have an order", errInvalidConfiguration,
+				field.Path, config.Name)
+		}
+	default:
+		return fmt.Errorf("%w: unknown merge strategy %q of field %s of DB syncer %s", errInvalidConfiguration,
+			field.Strategy, field.Path, config.Name)
+	}
+
+	return nil
+}
+
+// NewUnstructuredDBSyncerFactory returns a factory of a DBSyncer that works on unstructured objects, to be registered
+// under the name of the configuration.
+func NewUnstructuredDBSyncerFactory(config *UnstructuredDBSyncerConfig) DBSyncerFactory {
+	return func(ctrl.Manager) (DBSyncer, error) {
+		if err := config.Validate(); err != nil {
+			return nil, err
+		}
+
+		return &unstructuredDBSyncer{config: config}, nil
+	}
+}
+
+// unstructuredDBSyncer syncs the fields of an UnstructuredDBSyncerConfig.
+type unstructuredDBSyncer struct {
+	config *UnstructuredDBSyncerConfig
+}
+
+func (syncer *unstructuredDBSyncer) SpecGroupVersionKind() schema.GroupVersionKind {
+	return schema.FromAPIVersionAndKind(syncer.config.APIVersion, syncer.config.Kind)
+}
+
+// TargetGroupVersionKind returns the kind of the generated objects, or the kind of the spec objects if the fields are
+// written to their status.
+func (syncer *unstructuredDBSyncer) TargetGroupVersionKind() schema.GroupVersionKind {
+	if syncer.config.GeneratedObject != nil {
+		return schema.FromAPIVersionAndKind(syncer.config.GeneratedObject.APIVersion, syncer.config.GeneratedObject.Kind)
+	}
+
+	return syncer.SpecGroupVersionKind()
+}
+
+func (syncer *unstructuredDBSyncer) StatusTable() (string, string) {
+	return syncer.config.StatusTable, StatusRowNamespacedNameKey
+}
+
+func (syncer *unstructuredDBSyncer) ListSpecObjects(ctx context.Context, specStore SpecStore,
+	keys []string) ([]*SpecObject, error) {
+	return QuerySpecObjects(ctx, specStore, syncer.config.SpecTable, SpecRowNamespacedNameKey, keys)
+}
+
+func (syncer *unstructuredDBSyncer) FetchStatuses(ctx context.Context, statusStore StatusStore,
+	objects []*SpecObject) (map[string][]*LeafHubStatus, error) {
+	leafHubObjects, err := FetchStatusPayloads(ctx, statusStore, syncer.config.StatusTable,
+		StatusRowNamespacedNameKey, objects, func() interface{} { return &unstructured.Unstructured{} })
+	if err != nil {
+		return nil, fmt.Errorf("error in getting %s from DB - %w", syncer.config.StatusTable, err)
+	}
+
+	return leafHubObjects, nil
+}
+
+// Aggregate returns an unstructured object holding the merged fields, nil if no leaf hub reported the object. fields
+// that no leaf hub reported are omitted.
+func (syncer *unstructuredDBSyncer) Aggregate(_ *SpecObject, leafHubStatuses []*LeafHubStatus) (interface{},
+	[]string) {
+	if len(leafHubStatuses) == 0 { // no status resources found in DB
+		return nil, nil
+	}
+
+	aggregatedObject := &unstructured.Unstructured{Object: map[string]interface{}{}}
+
+	for _, field := range syncer.config.Fields {
+		if value, found := mergeField(field, leafHubStatuses); found {
+			// values of unstructured objects are deep-copyable, so setting them cannot fail
+			_ = unstructured.SetNestedField(aggregatedObject.Object, value, fieldPath(field)...)
+		}
+	}
+
+	return aggregatedObject, nil
+}
+
+func (syncer *unstructuredDBSyncer) Apply(ctx context.Context, k8sClient client.Client, object *SpecObject,
+	aggregatedStatus interface{}) (bool, error) {
+	aggregatedObject, ok := aggregatedStatus.(*unstructured.Unstructured)
+	if !ok {
+		return false, fmt.Errorf("%w: %T", errUnexpectedStatusType, aggregatedStatus)
+	}
+
+	if syncer.config.GeneratedObject != nil {
+		return syncer.updateGeneratedObject(ctx, k8sClient, object, aggregatedObject)
+	}
+
+	deployedObject := &unstructured.Unstructured{}
+	deployedObject.SetGroupVersionKind(syncer.SpecGroupVersionKind())
+
+	err := k8sClient.Get(ctx, client.ObjectKey{Name: object.Name, Namespace: object.Namespace}, deployedObject)
+	if err != nil {
+		if errors.IsNotFound(err) { // CR getting deleted
+			return false, nil
+		}
+
+		return false, newAPIError(operationGet, fmt.Errorf("failed to get %s {name=%s, namespace=%s} - %w",
+			syncer.config.Kind, object.Name, object.Namespace, err))
+	}
+
+	if err := labelStatusSynced(ctx, k8sClient, deployedObject); err != nil {
+		return false, err
+	}
+
+	// if object exists, clone and update
+	originalObject := deployedObject.DeepCopy()
+
+	syncer.setFields(deployedObject, aggregatedObject)
+
+	if equality.Semantic.DeepEqual(originalObject.Object, deployedObject.Object) {
+		return false, nil
+	}
+
+	err = k8sClient.Status().Patch(ctx, deployedObject, client.MergeFrom(originalObject))
+	if err != nil && !errors.IsNotFound(err) {
+		return false, newAPIError(operationPatch, fmt.Errorf("failed to update %s CR (name=%s, namespace=%s): %w",
+			syncer.config.Kind, object.Name, object.Namespace, err))
+	}
+
+	return true, nil
+}
+
+// returns whether the generated object was created or patched, it is not patched if the fields did not change.
+func (syncer *unstructuredDBSyncer) updateGeneratedObject(ctx context.Context, k8sClient client.Client,
+	object *SpecObject, aggregatedObject *unstructured.Unstructured) (bool, error) {
+	generatedObject := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	generatedObject.SetAPIVersion(syncer.config.GeneratedObject.APIVersion)
+	generatedObject.SetKind(syncer.config.GeneratedObject.Kind)
+	generatedObject.SetName(object.Name)
+	generatedObject.SetNamespace(object.Namespace)
+	syncer.setFields(generatedObject, aggregatedObject)
+
+	// set owner-reference so that the generated object is deleted when the spec object is
+	setOwnerReference(generatedObject, createOwnerReference(syncer.config.APIVersion, syncer.config.Kind,
+		object.Name, object.UID))
+
+	deployedObject := &unstructured.Unstructured{}
+	deployedObject.SetGroupVersionKind(generatedObject.GroupVersionKind())
+
+	err := k8sClient.Get(ctx, client.ObjectKey{Name: object.Name, Namespace: object.Namespace}, deployedObject)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			if err := createK8sResource(ctx, k8sClient, generatedObject); err != nil {
+				return false, newAPIError(operationCreate, fmt.Errorf("failed to create %s {name=%s, namespace=%s} - %w",
+					generatedObject.GetKind(), object.Name, object.Namespace, err))
+			}
+
+			return true, nil
+		}
+
+		return false, newAPIError(operationGet, fmt.Errorf("failed to get %s {name=%s, namespace=%s} - %w",
+			generatedObject.GetKind(), object.Name, object.Namespace, err))
+	}
+
+	// if object exists, clone and update
+	originalObject := deployedObject.DeepCopy()
+
+	syncer.setFields(deployedObject, aggregatedObject)
+
+	if equality.Semantic.DeepEqual(originalObject.Object, deployedObject.Object) {
+		return false, nil
+	}
+
+	err = k8sClient.Patch(ctx, deployedObject, client.MergeFrom(originalObject))
+	if err != nil && !errors.IsNotFound(err) {
+		return false, newAPIError(operationPatch, fmt.Errorf("failed to update %s CR (name=%s, namespace=%s): %w",
+			generatedObject.GetKind(), object.Name, object.Namespace, err))
+	}
+
+	return true, nil
+}
+
+// ListSyncedObjects returns the generated objects created by this syncer, or the spec objects whose configured fields
+// were written by this syncer, by their namespaced name.
+func (syncer *unstructuredDBSyncer) ListSyncedObjects(ctx context.Context,
+	k8sReader client.Reader) ([]*SyncedObject, error) {
+	objects := &unstructured.UnstructuredList{}
+
+	if syncer.config.GeneratedObject != nil {
+		objects.SetAPIVersion(syncer.config.GeneratedObject.APIVersion)
+		objects.SetKind(syncer.config.GeneratedObject.Kind + "List")
+	} else {
+		objects.SetAPIVersion(syncer.config.APIVersion)
+		objects.SetKind(syncer.config.Kind + "List")
+	}
+
+	if err := k8sReader.List(ctx, objects,
+		client.MatchingLabels{statusSyncLabelKey: statusSyncLabelValue}); err != nil {
+		return nil, newAPIError(operationList, fmt.Errorf("failed to list %s - %w", objects.GetKind(), err))
+	}
+
+	var syncedObjects []*SyncedObject
+
+	for i := range objects.Items {
+		object := &objects.Items[i]
+
+		syncedObject := &SyncedObject{Key: namespacedNameKey(object.GetNamespace(), object.GetName()), Object: object}
+
+		if syncer.config.GeneratedObject != nil {
+			syncedObject.OwnerUID = controllerUID(object)
+		} else if !syncer.hasFields(object) { // nothing to clear
+			continue
+		}
+
+		syncedObjects = append(syncedObjects, syncedObject)
+	}
+
+	return syncedObjects, nil
+}
+
+// Collect deletes the generated object, or removes the configured fields from the status of the spec object.
+func (syncer *unstructuredDBSyncer) Collect(ctx context.Context, k8sClient client.Client, object client.Object) error {
+	if syncer.config.GeneratedObject != nil {
+		return deleteK8sResource(ctx, k8sClient, object)
+	}
+
+	deployedObject, ok := object.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("%w: %T", errUnexpectedObjectType, object)
+	}
+
+	originalObject := deployedObject.DeepCopy()
+
+	syncer.setFields(deployedObject, &unstructured.Unstructured{Object: map[string]interface{}{}})
+
+	if err := k8sClient.Status().Patch(ctx, deployedObject, client.MergeFrom(originalObject)); err != nil &&
+		!errors.IsNotFound(err) {
+		return newAPIError(operationPatch, fmt.Errorf("failed to clear %s CR status (name=%s, namespace=%s): %w",
+			syncer.config.Kind, deployedObject.GetName(), deployedObject.GetNamespace(), err))
+	}
+
+	return nil
+}
+
+func (syncer *unstructuredDBSyncer) hasFields(object *unstructured.Unstructured) bool {
+	for _, field := range syncer.config.Fields {
+		if _, found, _ := unstructured.NestedFieldNoCopy(object.Object, fieldPath(field)...); found {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setFields copies the configured fields from the aggregated object, the fields it does not have are removed.
+func (syncer *unstructuredDBSyncer) setFields(target *unstructured.Unstructured,
+	aggregatedObject *unstructured.Unstructured) {
+	for _, field := range syncer.config.Fields {
+		value, found, _ := unstructured.NestedFieldCopy(aggregatedObject.Object, fieldPath(field)...)
+		if !found {
+			unstructured.RemoveNestedField(target.Object, fieldPath(field)...)
+			continue
+		}
+
+		_ = unstructured.SetNestedField(target.Object, value, fieldPath(field)...)
+	}
+}
+
+func fieldPath(field *UnstructuredFieldConfig) []string {
+	return strings.Split(field.Path, ".")
+}
+
+// mergeField merges the values of the field in the leaf hub objects, returns false if none of them has the field.
+func mergeField(field *UnstructuredFieldConfig, leafHubStatuses []*LeafHubStatus) (interface{}, bool) {
+	var values []interface{}
+
+	for _, leafHubStatus := range leafHubStatuses {
+		leafHubObject, ok := leafHubStatus.Status.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+
+		if value, found, err := unstructured.NestedFieldNoCopy(leafHubObject.Object, fieldPath(field)...); err == nil &&
+			found && value != nil {
+			values = append(values, value)
+		}
+	}
+
+	if len(values) == 0 {
+		return nil, false
+	}
+
+	switch field.Strategy {
+	case MergeStrategyConcatenate:
+		return concatenateValues(values), true
+	case MergeStrategySum:
+		return sumValues(values), true
+	case MergeStrategyWorstOf:
+		return worstOfValues(values, field.Order)
+	default:
+		return nil, false
+	}
+}
+
+func concatenateValues(values []interface{}) []interface{} {
+	concatenated := []interface{}{}
+
+	for _, value := range values {
+		if list, ok := value.([]interface{}); ok {
+			// assuming that the list items are unique across the hubs, all we need to do is a complete merge
+			concatenated = append(concatenated, list...)
+		}
+	}
+
+	return concatenated
+}
+
+func sumValues(values []interface{}) int64 {
+	var sum int64
+
+	for _, value := range values {
+		switch number := value.(type) {
+		case int64:
+			sum += number
+		case float64:
+			sum += int64(number)
+		}
+	}
+
+	return sum
+}
+
+// worstOfValues returns the value with the highest index in order, false if none of the values is in order.
+func worstOfValues(values []interface{}, order []string) (interface{}, bool) {
+	worstIndex := -1
+
+	for _, value := range values {
+		for index, orderedValue := range order {
+			if value == orderedValue && index > worstIndex {
+				worstIndex = index
+			}
+		}
+	}
+
+	if worstIndex < 0 {
+		return nil, false
+	}
+
+	return order[worstIndex], true
+}
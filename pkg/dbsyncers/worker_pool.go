@@ -0,0 +1,246 @@
+// Copyright (c) 2022 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package dbsyncers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errSyncerWorkersStopped = errors.New("syncer workers are stopped")
+
+// workerPool is a fixed-size pool of goroutines shared by all the DB syncers for handling single objects.
+type workerPool struct {
+	size int
+	jobs chan func()
+	// users counts the syncerWorkers that were not closed yet. on shutdown, the workers keep running until all the
+	// syncerWorkers are closed or until shutdownTimeout passes.
+	users           sync.WaitGroup
+	shutdownTimeout time.Duration
+}
+
+func newWorkerPool(size int, shutdownTimeout time.Duration) (*workerPool, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("%w: worker pool size must be positive, got %d", errInvalidConfiguration, size)
+	}
+
+	return &workerPool{
+		size:            size,
+		jobs:            make(chan func(), size),
+		shutdownTimeout: shutdownTimeout,
+	}, nil
+}
+
+// Start runs the workers of the pool until the context is cancelled and the DB syncers finished their shutdown.
+func (pool *workerPool) Start(ctx context.Context) error {
+	done := make(chan struct{})
+
+	for i := 0; i < pool.size; i++ {
+		go pool.runWorker(done)
+	}
+
+	<-ctx.Done() // blocking wait for stop event
+
+	usersClosed := make(chan struct{})
+
+	go func() {
+		pool.users.Wait()
+		close(usersClosed)
+	}()
+
+	select {
+	case <-usersClosed:
+	case <-time.After(pool.shutdownTimeout):
+	}
+
+	close(done)
+
+	return nil
+}
+
+func (pool *workerPool) runWorker(done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			pool.drain()
+			return
+		case job := <-pool.jobs:
+			job()
+		}
+	}
+}
+
+func (pool *workerPool) drain() {
+	for {
+		select {
+		case job := <-pool.jobs:
+			job()
+		default:
+			return
+		}
+	}
+}
+
+// queueDepth returns the number of jobs waiting for a free worker.
+func (pool *workerPool) queueDepth() int {
+	return len(pool.jobs)
+}
+
+// newSyncerWorkers returns a handle to the pool that limits the number of concurrent jobs of a single DB syncer.
+func (pool *workerPool) newSyncerWorkers(concurrency int) (*syncerWorkers, error) {
+	if concurrency <= 0 {
+		return nil, fmt.Errorf("%w: syncer concurrency must be positive, got %d", errInvalidConfiguration,
+			concurrency)
+	}
+
+	pool.users.Add(1)
+
+	return &syncerWorkers{
+		pool:        pool,
+		concurrency: concurrency,
+		released:    make(chan struct{}),
+		stopping:    make(chan struct{}),
+	}, nil
+}
+
+// syncerWorkers submits the jobs of a single DB syncer to the shared worker pool.
+type syncerWorkers struct {
+	pool *workerPool
+	// lock guards concurrency, active and released. released is closed and replaced whenever a job is released or
+	// the concurrency changes, to wake up the submits that wait for the concurrency limit.
+	lock        sync.Mutex
+	concurrency int
+	active      int
+	released    chan struct{}
+	pending     int64
+	running     sync.WaitGroup
+	stopping    chan struct{}
+	stopOnce    sync.Once
+	closeOnce   sync.Once
+}
+
+// submit blocks until the syncer is below its concurrency limit and the job is queued in the pool,
+// or until the context is cancelled or the workers are stopped.
+func (workers *syncerWorkers) submit(ctx context.Context, job func()) error {
+	atomic.AddInt64(&workers.pending, 1)
+
+	if err := workers.acquire(ctx); err != nil {
+		atomic.AddInt64(&workers.pending, -1)
+		return err
+	}
+
+	workers.running.Add(1)
+
+	wrappedJob := func() {
+		defer workers.release()
+		job()
+	}
+
+	select {
+	case workers.pool.jobs <- wrappedJob:
+		return nil
+	case <-ctx.Done():
+		workers.release()
+		return fmt.Errorf("failed to queue job in worker pool - %w", ctx.Err())
+	case <-workers.stopping:
+		workers.release()
+		return errSyncerWorkersStopped
+	}
+}
+
+// stop makes the following submits fail, the jobs that were already submitted still run.
+func (workers *syncerWorkers) stop() {
+	workers.stopOnce.Do(func() { close(workers.stopping) })
+}
+
+// close tells the pool that no more jobs are submitted, the pool workers are kept until all the syncers are closed.
+func (workers *syncerWorkers) close() {
+	workers.stop()
+	workers.closeOnce.Do(workers.pool.users.Done)
+}
+
+// acquire blocks until the syncer is below its concurrency limit, or until the context is cancelled or the workers
+// are stopped.
+func (workers *syncerWorkers) acquire(ctx context.Context) error {
+	for {
+		workers.lock.Lock()
+
+		if workers.active < workers.concurrency {
+			workers.active++
+			workers.lock.Unlock()
+
+			return nil
+		}
+
+		released := workers.released
+		workers.lock.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return fmt.Errorf("failed to acquire syncer worker - %w", ctx.Err())
+		case <-workers.stopping:
+			return errSyncerWorkersStopped
+		}
+	}
+}
+
+func (workers *syncerWorkers) release() {
+	workers.lock.Lock()
+	workers.active--
+	workers.notifyReleased()
+	workers.lock.Unlock()
+
+	atomic.AddInt64(&workers.pending, -1)
+	workers.running.Done()
+}
+
+// setConcurrency changes the concurrency limit of the syncer, the jobs above a lowered limit keep running.
+func (workers *syncerWorkers) setConcurrency(concurrency int) error {
+	if concurrency <= 0 {
+		return fmt.Errorf("%w: syncer concurrency must be positive, got %d", errInvalidConfiguration,
+			concurrency)
+	}
+
+	workers.lock.Lock()
+	defer workers.lock.Unlock()
+
+	workers.concurrency = concurrency
+	workers.notifyReleased()
+
+	return nil
+}
+
+// getConcurrency returns the concurrency limit of the syncer.
+func (workers *syncerWorkers) getConcurrency() int {
+	workers.lock.Lock()
+	defer workers.lock.Unlock()
+
+	return workers.concurrency
+}
+
+// notifyReleased wakes up the submits that wait for the concurrency limit, must be called with the lock held.
+func (workers *syncerWorkers) notifyReleased() {
+	close(workers.released)
+	workers.released = make(chan struct{})
+}
+
+// wait blocks until all the submitted jobs of the syncer are done.
+func (workers *syncerWorkers) wait() {
+	workers.running.Wait()
+}
+
+// pendingJobs returns the number of jobs of the syncer that are either waiting or running.
+func (workers *syncerWorkers) pendingJobs() int64 {
+	return atomic.LoadInt64(&workers.pending)
+}
+
+// queueDepth returns the number of jobs waiting for a free worker in the shared pool.
+func (workers *syncerWorkers) queueDepth() int {
+	return workers.pool.queueDepth()
+}